@@ -8,7 +8,8 @@ import (
 	"log"
 	"net/url"
 	"os"
-	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -75,10 +76,14 @@ func CommandAction(cmd *cobra.Command, args []string) {
 	// if the requested action does not exist, report available choices
 	if _, exists := problemType.Actions[action]; !exists {
 		fmt.Printf("available actions for problem type %s:\n", problemType.Name)
-		for elt := range problemType.Actions {
+		for elt, details := range problemType.Actions {
 			if elt == "grade" {
 				continue
 			}
+			if details.Warmup {
+				fmt.Printf("   %s (rebuilds the dependency cache, authors only)\n", elt)
+				continue
+			}
 			fmt.Printf("   %s\n", elt)
 		}
 		log.Fatalf("use '%s action [action]' to initiate an action", os.Args[0])
@@ -123,6 +128,42 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 		return
 	}
 
+	// forward stdin to the server for interactive actions
+	if action := bundle.ProblemType.Actions[bundle.Commit.Action]; action != nil && action.Interactive {
+		if isTerminal(os.Stdin.Fd()) {
+			restore, err := makeRaw(os.Stdin.Fd())
+			if err != nil {
+				log.Printf("unable to put terminal in raw mode: %v", err)
+			} else {
+				rawMode = true
+				ptyMode = action.PTY
+				defer func() {
+					restore()
+					rawMode = false
+					ptyMode = false
+				}()
+				if ptyMode {
+					log.Printf("press ctrl-] to end the session\r")
+				} else {
+					log.Printf("press ctrl-D to close stdin, ctrl-C to end the session\r")
+				}
+			}
+		}
+
+		// keep the remote terminal the same size as the local one
+		if ptyMode {
+			sendSize := func() {
+				if size, err := terminalSize(os.Stdout.Fd()); err == nil {
+					sendRequest(socket, &DaycareRequest{Resize: size})
+				}
+			}
+			sendSize()
+			stop := notifyResize(sendSize)
+			defer stop()
+		}
+		go forwardStdin(socket)
+	}
+
 	// start listening for events
 	for {
 		reply := new(DaycareResponse)
@@ -145,15 +186,30 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 
 		case reply.Event != nil:
 			switch reply.Event.Event {
-			case "exec", "stdin", "stdout", "exit", "error":
-				fmt.Printf("%s", reply.Event.Dump())
-			case "stderr":
-				fmt.Printf("%s", reply.Event.Dump())
+			case "stdin":
+				// a terminal echoes its own input
+				if !ptyMode {
+					fmt.Printf("%s", rawText(reply.Event.Dump()))
+				}
+			case "stdout", "stderr":
+				if ptyMode {
+					// terminal output is already formatted for the screen
+					fmt.Printf("%s", reply.Event.Dump())
+				} else {
+					fmt.Printf("%s", rawText(reply.Event.Dump()))
+				}
+			case "exec", "exit", "error", "queued", "stage", "usage":
+				fmt.Printf("%s", rawText(reply.Event.Dump()))
 			case "files":
 				if reply.Event.Files != nil {
 					for name, contents := range reply.Event.Files {
+						path, err := SafeJoin(directory, name)
+						if err != nil {
+							log.Printf("refusing to download file from daycare: %v\r", err)
+							continue
+						}
 						log.Printf("downloading file %s\r", name)
-						if err := ioutil.WriteFile(filepath.Join(directory, filepath.FromSlash(name)), contents, 0644); err != nil {
+						if err := ioutil.WriteFile(path, contents, 0644); err != nil {
 							log.Printf("error saving file: %v\r", err)
 						}
 					}
@@ -167,8 +223,75 @@ func runInteractiveSession(bundle *CommitBundle, args []string, directory string
 	}
 }
 
+// forwardStdin reads from stdin and sends it to the daycare until stdin
+// is closed. In raw mode, ctrl-D closes stdin and ctrl-C ends the session.
+// When the action runs on a terminal, keystrokes are passed through
+// unchanged and ctrl-] ends the session.
+func forwardStdin(socket *websocket.Conn) {
+	buf := make([]byte, 4096)
+	for {
+		n, err := os.Stdin.Read(buf)
+		if n > 0 {
+			data, closeStdin := buf[:n], false
+			if ptyMode {
+				if i := bytes.IndexByte(data, 0x1d); i >= 0 {
+					// ctrl-]: end the session
+					socket.Close()
+					return
+				}
+			} else if rawMode {
+				if i := bytes.IndexByte(data, 0x03); i >= 0 {
+					// ctrl-C: end the session
+					socket.Close()
+					return
+				}
+				if i := bytes.IndexByte(data, 0x04); i >= 0 {
+					// ctrl-D: close stdin after sending anything typed before it
+					data, closeStdin = data[:i], true
+				}
+
+				// the enter key sends a carriage return in raw mode
+				data = bytes.Replace(data, []byte("\r"), []byte("\n"), -1)
+			}
+			if err := sendRequest(socket, &DaycareRequest{Stdin: data, CloseStdin: closeStdin}); err != nil {
+				return
+			}
+			if closeStdin {
+				return
+			}
+		}
+		if err != nil {
+			sendRequest(socket, &DaycareRequest{CloseStdin: true})
+			return
+		}
+	}
+}
+
+// sendRequest writes a follow-up request to the daycare. Input and window
+// size changes are sent from different goroutines, so writes are serialized.
+func sendRequest(socket *websocket.Conn, req *DaycareRequest) error {
+	socketWriteLock.Lock()
+	defer socketWriteLock.Unlock()
+	dumpOutgoing(req)
+	return socket.WriteJSON(req)
+}
+
+var socketWriteLock sync.Mutex
+
+// rawText adds carriage returns to line endings when the terminal is in raw mode.
+func rawText(s string) string {
+	if !rawMode {
+		return s
+	}
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	return strings.Replace(s, "\n", "\r\n", -1)
+}
+
 var rawMode = false
 
+// ptyMode is set when the action runs on a terminal in the container.
+var ptyMode = false
+
 func dumpOutgoing(msg interface{}) {
 	if Config.apiDump {
 		raw, err := json.MarshalIndent(msg, "", "    ")
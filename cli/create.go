@@ -300,6 +300,23 @@ func gatherAuthor(now time.Time, isUpdate bool, action string, startDir string)
 		}
 	}
 
+	// check the options against the schema and the problem types
+	if err := ValidateProblemOptions(problem.Options); err != nil {
+		log.Printf("bad option in %s: %v", ProblemConfigName, err)
+		log.Printf("the options a problem can set are:")
+		for _, elt := range ProblemOptions {
+			log.Printf("  %-12s %s", elt.Name, elt.Help)
+		}
+		log.Fatalf("please fix the option and try again")
+	}
+	for _, action := range OptionActions(problem.Options) {
+		for name, problemType := range problemTypes {
+			if _, exists := problemType.Actions[action]; !exists {
+				log.Fatalf("an option in %s applies to the %s action, but problem type %s has no such action", ProblemConfigName, action, name)
+			}
+		}
+	}
+
 	// start forming the problem bundle
 	unsigned := &ProblemBundle{
 		Problem: problem,
@@ -369,6 +386,7 @@ func gatherAuthor(now time.Time, isUpdate bool, action string, startDir string)
 
 		// read files
 		starter, solution, root := make(map[string][]byte), make(map[string][]byte), make(map[string][]byte)
+		executable := make(map[string]bool)
 		stepdir := directory
 		if !single {
 			stepdir = filepath.Join(directory, strconv.FormatInt(i, 10))
@@ -414,6 +432,9 @@ func gatherAuthor(now time.Time, isUpdate bool, action string, startDir string)
 			if err != nil {
 				log.Fatalf("error reading %s: %v", relpath, err)
 			}
+			if info.Mode()&0111 != 0 {
+				executable[relpath] = true
+			}
 
 			// pick out solution/starter files
 			if parts := strings.SplitN(relpath, "/", 2); len(parts) == 0 {
@@ -493,13 +514,21 @@ func gatherAuthor(now time.Time, isUpdate bool, action string, startDir string)
 		}
 
 		// copy support files into the step
+		step.FileMeta = make(map[string]FileMeta)
 		for name, contents := range root {
 			step.Files[name] = contents
+			if executable[name] {
+				step.FileMeta[name] = FileMeta{Executable: true}
+			}
 		}
 
 		// copy the starter files into the step
+		// starter files may have come from the root directory
 		for name, contents := range starter {
 			step.Files[name] = contents
+			if executable["_starter/"+name] || executable[name] {
+				step.FileMeta[name] = FileMeta{Executable: true}
+			}
 		}
 
 		// copy the whitelist for the step
@@ -513,9 +542,13 @@ func gatherAuthor(now time.Time, isUpdate bool, action string, startDir string)
 		for name := range whitelist {
 			unused[name] = true
 		}
+		commit.FileMeta = make(map[string]FileMeta)
 		for name, contents := range solution {
 			if whitelist[name] {
 				commit.Files[name] = contents
+				if executable["_solution/"+name] || executable[name] {
+					commit.FileMeta[name] = FileMeta{Executable: true}
+				}
 				delete(unused, name)
 			} else {
 				fmt.Printf("  warning: skipping solution file %q\n", name)
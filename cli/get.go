@@ -161,8 +161,10 @@ func getAssignment(assignment *Assignment, rootDir, prettyRoot string) string {
 
 		// save the step files
 		files := make(map[string][]byte)
+		fileMeta := make(map[string]FileMeta)
 		for name, contents := range step.Files {
 			files[filepath.FromSlash(name)] = contents
+			fileMeta[filepath.FromSlash(name)] = step.FileMeta[name]
 		}
 		files[filepath.Join("doc", "index.html")] = []byte(step.Instructions)
 
@@ -176,6 +178,7 @@ func getAssignment(assignment *Assignment, rootDir, prettyRoot string) string {
 			}
 			for name, contents := range commit.Files {
 				files[filepath.FromSlash(name)] = contents
+				fileMeta[filepath.FromSlash(name)] = commit.FileMeta[name]
 			}
 		}
 
@@ -185,9 +188,10 @@ func getAssignment(assignment *Assignment, rootDir, prettyRoot string) string {
 				fmt.Printf("warning: problem type file is overwriting problem file: %s\n", filepath.Join(target, filepath.FromSlash(name)))
 			}
 			files[filepath.FromSlash(name)] = contents
+			fileMeta[filepath.FromSlash(name)] = types[step.ProblemType].FileMeta[name]
 		}
 
-		updateFiles(target, files, nil, false)
+		updateFiles(target, files, fileMeta, nil, false)
 
 		// does this commit indicate the step was finished and needs to advance?
 		if commit != nil && commit.ReportCard != nil && commit.ReportCard.Passed && commit.Score == 1.0 {
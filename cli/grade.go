@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	. "github.com/russross/codegrinder/types"
@@ -63,6 +64,11 @@ func CommandGrade(cmd *cobra.Command, args []string) {
 		fmt.Printf("  solution for step %d failed\n", commit.Step)
 		if commit.ReportCard != nil {
 			fmt.Printf("  ReportCard: %s\n", commit.ReportCard.Note)
+			for _, stage := range commit.ReportCard.Stages {
+				fmt.Printf("    %s\n", stage)
+			}
+			printResults(commit.ReportCard, "error", "Errors (tests that could not run to completion)")
+			printResults(commit.ReportCard, "failed", "Failed tests")
 		}
 
 		// play the transcript
@@ -71,3 +77,34 @@ func CommandGrade(cmd *cobra.Command, args []string) {
 		}
 	}
 }
+
+// printResults lists the results with the given outcome, giving where each
+// went wrong and the first line of its details.
+func printResults(card *ReportCard, outcome, heading string) {
+	first := true
+	for _, result := range card.Results {
+		if result.Outcome != outcome {
+			continue
+		}
+		if first {
+			fmt.Printf("  %s:\n", heading)
+			first = false
+		}
+		name := result.Name
+		if result.Stage != "" {
+			name = result.Stage + ": " + name
+		}
+		if result.Location != nil {
+			name += " (" + result.Location.String() + ")"
+		} else if result.Context != "" {
+			name += " (" + result.Context + ")"
+		}
+		fmt.Printf("    %s\n", name)
+		if details := strings.TrimSpace(result.Details); details != "" {
+			if newline := strings.IndexByte(details, '\n'); newline >= 0 {
+				details = details[:newline]
+			}
+			fmt.Printf("      %s\n", details)
+		}
+	}
+}
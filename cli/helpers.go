@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"runtime"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -40,15 +41,18 @@ func nextStep(directory string, info *ProblemInfo, problem *Problem, commit *Com
 
 	// gather all the files for the new step
 	files := make(map[string][]byte)
+	fileMeta := make(map[string]FileMeta)
 	if commit != nil {
 		for name, contents := range commit.Files {
 			files[filepath.FromSlash(name)] = contents
+			fileMeta[filepath.FromSlash(name)] = commit.FileMeta[name]
 		}
 	}
 
 	// commit files may be overwritten by new step files
 	for name, contents := range newStep.Files {
 		files[filepath.FromSlash(name)] = contents
+		fileMeta[filepath.FromSlash(name)] = newStep.FileMeta[name]
 	}
 	files[filepath.Join("doc", "index.html")] = []byte(newStep.Instructions)
 	for name, contents := range types[newStep.ProblemType].Files {
@@ -56,6 +60,7 @@ func nextStep(directory string, info *ProblemInfo, problem *Problem, commit *Com
 			fmt.Printf("warning: problem type file is overwriting problem file: %s\n", name)
 		}
 		files[filepath.FromSlash(name)] = contents
+		fileMeta[filepath.FromSlash(name)] = types[newStep.ProblemType].FileMeta[name]
 	}
 
 	// files from the old problem type and old step may need to be removed
@@ -67,15 +72,27 @@ func nextStep(directory string, info *ProblemInfo, problem *Problem, commit *Com
 		oldFiles[filepath.FromSlash(name)] = struct{}{}
 	}
 
-	updateFiles(directory, files, oldFiles, false)
+	updateFiles(directory, files, fileMeta, oldFiles, false)
 
 	info.Step++
 	return true
 }
 
-func updateFiles(directory string, files map[string][]byte, oldFiles map[string]struct{}, chatty bool) {
+// updateFiles writes files to disk, keyed by local path. A file with an
+// entry in fileMeta is given execute permission or loses it as the entry
+// says. Other existing files keep their mode, and other new files are not
+// executable.
+func updateFiles(directory string, files map[string][]byte, fileMeta map[string]FileMeta, oldFiles map[string]struct{}, chatty bool) {
 	for name, contents := range files {
-		path := filepath.Join(directory, name)
+		path, err := SafeJoin(directory, filepath.ToSlash(name))
+		if err != nil {
+			log.Fatalf("refusing to save file: %v", err)
+		}
+		meta, explicit := fileMeta[name]
+		mode := os.FileMode(0644)
+		if meta.Executable {
+			mode = 0755
+		}
 		ondisk, err := ioutil.ReadFile(path)
 		if err != nil && os.IsNotExist(err) {
 			if chatty {
@@ -84,19 +101,34 @@ func updateFiles(directory string, files map[string][]byte, oldFiles map[string]
 			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
 				log.Fatalf("error creating directory %s: %v", filepath.Dir(path), err)
 			}
-			if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+			if err := ioutil.WriteFile(path, contents, mode); err != nil {
 				log.Fatalf("error saving %s: %v", name, err)
 			}
+			continue
 		} else if err != nil {
 			log.Fatalf("error reading %s: %v", name, err)
 		} else if !bytes.Equal(ondisk, contents) {
 			if chatty {
 				fmt.Printf("updating file: %s\n", name)
 			}
-			if err := ioutil.WriteFile(path, contents, 0644); err != nil {
+			if err := ioutil.WriteFile(path, contents, mode); err != nil {
 				log.Fatalf("error saving %s: %v", name, err)
 			}
 		}
+
+		// existing files keep their old mode unless it is changed explicitly
+		if !explicit || runtime.GOOS == "windows" {
+			continue
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			log.Fatalf("error checking %s: %v", name, err)
+		}
+		if (info.Mode()&0111 != 0) != meta.Executable {
+			if err := os.Chmod(path, mode); err != nil {
+				log.Fatalf("error setting mode of %s: %v", name, err)
+			}
+		}
 	}
 
 	if oldFiles == nil {
@@ -106,7 +138,11 @@ func updateFiles(directory string, files map[string][]byte, oldFiles map[string]
 		if _, exists := files[name]; exists {
 			continue
 		}
-		path := filepath.Join(directory, name)
+		path, err := SafeJoin(directory, filepath.ToSlash(name))
+		if err != nil {
+			log.Printf("refusing to remove file: %v", err)
+			continue
+		}
 		if _, err := os.Stat(path); err == nil {
 			if chatty {
 				fmt.Printf("removing file: %s\n", name)
@@ -164,20 +200,24 @@ func gatherStudent(now time.Time, startDir string) (*ProblemType, *Problem, *Pro
 
 	// make sure all step and problem type files are up to date
 	stepFiles := make(map[string][]byte)
+	stepMeta := make(map[string]FileMeta)
 	for name, contents := range step.Files {
 		// do not overwrite student files
 		if _, exists := step.Whitelist[name]; !exists {
 			stepFiles[filepath.FromSlash(name)] = contents
+			stepMeta[filepath.FromSlash(name)] = step.FileMeta[name]
 		}
 	}
 	for name, contents := range problemType.Files {
 		stepFiles[filepath.FromSlash(name)] = contents
+		stepMeta[filepath.FromSlash(name)] = problemType.FileMeta[name]
 	}
 	stepFiles[filepath.Join("doc", "index.html")] = []byte(step.Instructions)
-	updateFiles(problemDir, stepFiles, nil, true)
+	updateFiles(problemDir, stepFiles, stepMeta, nil, true)
 
 	// gather the commit files from the file system
 	files := make(map[string][]byte)
+	fileMeta := make(map[string]FileMeta)
 	var missing []string
 	for name := range step.Whitelist {
 		path := filepath.Join(problemDir, filepath.FromSlash(name))
@@ -188,6 +228,19 @@ func gatherStudent(now time.Time, startDir string) (*ProblemType, *Problem, *Pro
 			continue
 		}
 		files[name] = contents
+
+		// windows has no execute bit, so keep the one from the step
+		meta := FileMeta{Executable: step.FileMeta[name].Executable}
+		if runtime.GOOS != "windows" {
+			info, err := os.Stat(path)
+			if err != nil {
+				log.Fatalf("error checking %s: %v", name, err)
+			}
+			meta.Executable = info.Mode()&0111 != 0
+		}
+		if meta.Executable {
+			fileMeta[name] = meta
+		}
 	}
 	if len(missing) > 0 {
 		log.Print("did not find all the expected files")
@@ -204,6 +257,7 @@ func gatherStudent(now time.Time, startDir string) (*ProblemType, *Problem, *Pro
 		ProblemID:    info.ID,
 		Step:         info.Step,
 		Files:        files,
+		FileMeta:     fileMeta,
 		CreatedAt:    now,
 		UpdatedAt:    now,
 	}
@@ -291,9 +345,14 @@ func mustConfirmCommitBundle(bundle *CommitBundle, args []string) *CommitBundle
 	}
 
 	// start listening for events
+	lastError := ""
 	for {
 		reply := new(DaycareResponse)
 		if err := socket.ReadJSON(reply); err != nil {
+			if lastError != "" {
+				// the daycare explained why it stopped
+				log.Fatalf("%s", lastError)
+			}
 			log.Fatalf("socket error reading event: %v", err)
 			break
 		}
@@ -307,7 +366,14 @@ func mustConfirmCommitBundle(bundle *CommitBundle, args []string) *CommitBundle
 			return reply.CommitBundle
 
 		case reply.Event != nil:
-			// ignore the streamed data
+			// ignore the streamed data, but let the user know if they are
+			// waiting in line and which stage is running
+			switch reply.Event.Event {
+			case "queued", "stage":
+				fmt.Print(reply.Event.Dump())
+			case "error":
+				lastError = reply.Event.Error
+			}
 
 		default:
 			log.Fatalf("unexpected reply from server")
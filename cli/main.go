@@ -186,6 +186,9 @@ func main() {
 		}
 		cmdType.Flags().BoolP("remove", "r", false, "remove problem type files")
 		cmdType.Flags().BoolP("list", "l", false, "list known problem types and then quit")
+		cmdType.Flags().Bool("pin", false, "pin the problem type to the image ID the daycares report (admins only)")
+		cmdType.Flags().String("image", "", "with --pin, the image ID to pin to instead")
+		cmdType.Flags().Bool("unpin", false, "let the problem type use any image with its tag (admins only)")
 		cmdGrind.AddCommand(cmdType)
 	}
 
@@ -47,6 +47,7 @@ func CommandReset(cmd *cobra.Command, args []string) {
 
 	// gather all the files that make up this step
 	files := make(map[string][]byte)
+	fileMeta := make(map[string]FileMeta)
 
 	// get the commit from the previous step if applicable
 	if info.Step > 1 {
@@ -54,16 +55,19 @@ func CommandReset(cmd *cobra.Command, args []string) {
 		mustGetObject(fmt.Sprintf("/assignments/%d/problems/%d/steps/%d/commits/last", assignment.ID, problem.ID, info.Step-1), nil, commit)
 		for name, contents := range commit.Files {
 			files[filepath.FromSlash(name)] = contents
+			fileMeta[filepath.FromSlash(name)] = commit.FileMeta[name]
 		}
 	}
 
 	// commit files may be overwritten by new step files
 	for name, contents := range step.Files {
 		files[filepath.FromSlash(name)] = contents
+		fileMeta[filepath.FromSlash(name)] = step.FileMeta[name]
 	}
 	files[filepath.Join("doc", "index.html")] = []byte(step.Instructions)
 	for name, contents := range problemType.Files {
 		files[filepath.FromSlash(name)] = contents
+		fileMeta[filepath.FromSlash(name)] = problemType.FileMeta[name]
 	}
 
 	// report which files have changed since the step started
@@ -91,7 +95,7 @@ func CommandReset(cmd *cobra.Command, args []string) {
 	}
 
 	// update non-student files and student files that were selected
-	updateFiles(problemDir, files, nil, true)
+	updateFiles(problemDir, files, fileMeta, nil, true)
 
 	if !found {
 		fmt.Println("no student files have been modified since the beginning of this step")
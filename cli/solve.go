@@ -32,8 +32,10 @@ func CommandSolve(cmd *cobra.Command, args []string) {
 		log.Fatalf("no solution files found")
 	}
 	files := make(map[string][]byte)
+	fileMeta := make(map[string]FileMeta)
 	for name, contents := range step.Solution {
 		files[filepath.FromSlash(name)] = contents
+		fileMeta[filepath.FromSlash(name)] = step.SolutionMeta[name]
 	}
-	updateFiles(problemDir, files, nil, true)
+	updateFiles(problemDir, files, fileMeta, nil, true)
 }
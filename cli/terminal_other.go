@@ -0,0 +1,29 @@
+//go:build !linux && !darwin && !windows
+
+package main
+
+import (
+	"fmt"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// isTerminal always reports false on platforms without terminal support.
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+// makeRaw is not supported on this platform.
+func makeRaw(fd uintptr) (func(), error) {
+	return nil, fmt.Errorf("raw terminal mode is not supported on this platform")
+}
+
+// terminalSize is not supported on this platform.
+func terminalSize(fd uintptr) (*TerminalSize, error) {
+	return nil, fmt.Errorf("terminal size is not supported on this platform")
+}
+
+// notifyResize does nothing on this platform.
+func notifyResize(f func()) func() {
+	return func() {}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"syscall"
+	"time"
+	"unsafe"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+const (
+	enableProcessedInput = 0x0001
+	enableLineInput      = 0x0002
+	enableEchoInput      = 0x0004
+)
+
+// resizePollInterval is how often the console size is checked, since
+// Windows has no signal for window size changes.
+const resizePollInterval = 250 * time.Millisecond
+
+var (
+	kernel32                       = syscall.NewLazyDLL("kernel32.dll")
+	procSetConsoleMode             = kernel32.NewProc("SetConsoleMode")
+	procGetConsoleScreenBufferInfo = kernel32.NewProc("GetConsoleScreenBufferInfo")
+)
+
+type coord struct {
+	X, Y int16
+}
+
+type smallRect struct {
+	Left, Top, Right, Bottom int16
+}
+
+type consoleScreenBufferInfo struct {
+	Size              coord
+	CursorPosition    coord
+	Attributes        uint16
+	Window            smallRect
+	MaximumWindowSize coord
+}
+
+func setConsoleMode(handle syscall.Handle, mode uint32) error {
+	if r, _, err := procSetConsoleMode.Call(uintptr(handle), uintptr(mode)); r == 0 {
+		return err
+	}
+	return nil
+}
+
+// isTerminal reports whether the given handle is a console.
+func isTerminal(fd uintptr) bool {
+	var mode uint32
+	return syscall.GetConsoleMode(syscall.Handle(fd), &mode) == nil
+}
+
+// makeRaw disables line editing, echo, and ctrl-C processing on the console
+// and returns a function that restores the previous state.
+func makeRaw(fd uintptr) (func(), error) {
+	handle := syscall.Handle(fd)
+	var old uint32
+	if err := syscall.GetConsoleMode(handle, &old); err != nil {
+		return nil, fmt.Errorf("getting console mode: %v", err)
+	}
+	raw := old &^ (enableProcessedInput | enableLineInput | enableEchoInput)
+	if err := setConsoleMode(handle, raw); err != nil {
+		return nil, fmt.Errorf("setting console to raw mode: %v", err)
+	}
+
+	return func() {
+		setConsoleMode(handle, old)
+	}, nil
+}
+
+// terminalSize reports the size of the visible console window.
+func terminalSize(fd uintptr) (*TerminalSize, error) {
+	var info consoleScreenBufferInfo
+	if r, _, err := procGetConsoleScreenBufferInfo.Call(fd, uintptr(unsafe.Pointer(&info))); r == 0 {
+		return nil, err
+	}
+	return &TerminalSize{
+		Rows: int(info.Window.Bottom-info.Window.Top) + 1,
+		Cols: int(info.Window.Right-info.Window.Left) + 1,
+	}, nil
+}
+
+// notifyResize calls f each time the console window changes size
+// and returns a function that stops the notifications.
+func notifyResize(f func()) func() {
+	stop := make(chan struct{})
+	go func() {
+		last, _ := terminalSize(uintptr(syscall.Stdout))
+		ticker := time.NewTicker(resizePollInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				size, err := terminalSize(uintptr(syscall.Stdout))
+				if err != nil || last != nil && *size == *last {
+					continue
+				}
+				last = size
+				f()
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+	}
+}
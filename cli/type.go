@@ -3,6 +3,7 @@ package main
 import (
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
@@ -17,6 +18,9 @@ func CommandType(cmd *cobra.Command, args []string) {
 
 	remove := cmd.Flag("remove").Value.String() == "true"
 	list := cmd.Flag("list").Value.String() == "true"
+	pin := cmd.Flag("pin").Value.String() == "true"
+	image := cmd.Flag("image").Value.String()
+	unpin := cmd.Flag("unpin").Value.String() == "true"
 
 	if list {
 		if len(args) != 0 || remove {
@@ -46,6 +50,29 @@ func CommandType(cmd *cobra.Command, args []string) {
 		return
 	}
 
+	if pin || unpin {
+		if len(args) != 1 || pin && unpin || remove {
+			cmd.Help()
+			os.Exit(1)
+		}
+		path := fmt.Sprintf("/problem_types/%s/image_digest", args[0])
+		if unpin {
+			doRequest(path, nil, "DELETE", nil, nil, false)
+			fmt.Printf("problem type %s is no longer pinned to an image\n", args[0])
+			return
+		}
+		params := make(url.Values)
+		if image != "" {
+			params.Add("digest", image)
+		}
+		problemType := new(ProblemType)
+		mustPutObject(path, params, nil, problemType)
+		fmt.Printf("problem type %s is pinned to image %s\n", problemType.Name, problemType.ImageDigest)
+		return
+	} else if image != "" {
+		fmt.Println("warning: --image is only used with --pin")
+	}
+
 	// figure out the problem type and directory
 	directory, problemTypeName := ".", ""
 	if len(args) == 0 {
@@ -81,11 +108,13 @@ func CommandType(cmd *cobra.Command, args []string) {
 		for name := range problemType.Files {
 			oldFiles[filepath.FromSlash(name)] = struct{}{}
 		}
-		updateFiles(directory, files, oldFiles, true)
+		updateFiles(directory, files, nil, oldFiles, true)
 	} else {
+		fileMeta := make(map[string]FileMeta)
 		for name, contents := range problemType.Files {
 			files[filepath.FromSlash(name)] = contents
+			fileMeta[filepath.FromSlash(name)] = problemType.FileMeta[name]
 		}
-		updateFiles(directory, files, nil, true)
+		updateFiles(directory, files, fileMeta, nil, true)
 	}
 }
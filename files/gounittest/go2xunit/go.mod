@@ -1,3 +0,0 @@
-module github.com/tebeka/go2xunit
-
-go 1.13
@@ -1,7 +0,0 @@
-package lib
-
-// Options is library options
-var Options struct {
-	// FailOnRace will mark test a failed if there is a race
-	FailOnRace bool
-}
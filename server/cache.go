@@ -0,0 +1,47 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// cacheVolumePrefix starts the name of each problem type's dependency cache volume.
+const cacheVolumePrefix = "codegrinder-cache-"
+
+// cacheVolume names the dependency cache volume for a problem type.
+func cacheVolume(problemType string) string {
+	return cacheVolumePrefix + problemType
+}
+
+// dependencyCaches keeps containers from reading a problem type's
+// dependency cache while a warmup action is rebuilding it.
+type dependencyCaches struct {
+	sync.Mutex
+	locks map[string]*sync.RWMutex
+}
+
+var caches = &dependencyCaches{locks: make(map[string]*sync.RWMutex)}
+
+// Claim waits until a problem type's cache can be used, exclusively for a
+// warmup and shared otherwise. It returns a function that gives it up.
+func (c *dependencyCaches) Claim(problemType string, warmup bool) func() {
+	c.Lock()
+	lock := c.locks[problemType]
+	if lock == nil {
+		lock = new(sync.RWMutex)
+		c.locks[problemType] = lock
+	}
+	c.Unlock()
+
+	if !warmup {
+		lock.RLock()
+		return lock.RUnlock
+	}
+	log.Printf("waiting for running containers to finish with the dependency cache for %s", problemType)
+	lock.Lock()
+	log.Printf("rebuilding the dependency cache for %s", problemType)
+	return func() {
+		log.Printf("finished rebuilding the dependency cache for %s", problemType)
+		lock.Unlock()
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-martini/martini"
+	"github.com/martini-contrib/render"
+	. "github.com/russross/codegrinder/types"
+)
+
+// canaryRetryInterval is how soon a failed canary is tried again.
+const canaryRetryInterval = 5 * time.Minute
+
+// defaultCanaryInterval is how often passing canaries are run again.
+const defaultCanaryInterval = time.Hour
+
+// ProblemTypeCanary is a known-good commit that a daycare runs to check that
+// it can grade a problem type. The files are run with the given action and
+// the report card must match the expected one. Canaries live on the TA in
+// canaries/<problem type>/, with canary.json holding the action and expected
+// report card and files/ holding the commit's files. Files that are
+// executable there are executable in the container.
+type ProblemTypeCanary struct {
+	Action   string              `json:"action"`
+	Files    map[string][]byte   `json:"files"`
+	FileMeta map[string]FileMeta `json:"fileMeta,omitempty"`
+	Expected *ReportCard         `json:"expected"`
+}
+
+// CanaryBundle is what the TA sends a daycare to run a canary.
+type CanaryBundle struct {
+	ProblemType *ProblemType       `json:"problemType"`
+	Canary      *ProblemTypeCanary `json:"canary"`
+}
+
+// CanaryResult is the outcome of the latest canary run for a problem type.
+type CanaryResult struct {
+	ProblemType string        `json:"problemType"`
+	Action      string        `json:"action,omitempty"`
+	Passed      bool          `json:"passed"`
+	Note        string        `json:"note,omitempty"`
+	ReportCard  *ReportCard   `json:"reportCard,omitempty"`
+	Time        time.Time     `json:"time"`
+	Duration    time.Duration `json:"duration"`
+}
+
+// GetDaycareCanary handles a request to /daycare_canaries/:problem_type,
+// returning the problem type and its canary to a daycare. The request must
+// be signed with the daycare secret. It returns 404 if the problem type has
+// no canary.
+func GetDaycareCanary(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, render render.Render) {
+	name := params["problem_type"]
+	if !checkDaycareRequest(w, r, name) {
+		return
+	}
+
+	problemType, err := getProblemType(tx, name)
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	canary, err := loadCanary(name)
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "loading canary for problem type %s: %v", name, err)
+		return
+	}
+	if canary == nil {
+		loggedHTTPErrorf(w, http.StatusNotFound, "problem type %s has no canary", name)
+		return
+	}
+
+	render.JSON(http.StatusOK, &CanaryBundle{ProblemType: problemType, Canary: canary})
+}
+
+// loadCanary reads the canary for a problem type from disk.
+// It returns nil if the problem type has none.
+func loadCanary(name string) (*ProblemTypeCanary, error) {
+	dir := filepath.Join(root, "canaries", name)
+	raw, err := ioutil.ReadFile(filepath.Join(dir, "canary.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	canary := new(ProblemTypeCanary)
+	if err := json.Unmarshal(raw, canary); err != nil {
+		return nil, fmt.Errorf("parsing canary.json: %v", err)
+	}
+	if canary.Action == "" {
+		return nil, fmt.Errorf("canary.json must name an action")
+	}
+	if canary.Expected == nil {
+		return nil, fmt.Errorf("canary.json must include an expected report card")
+	}
+
+	// gather the commit files
+	canary.Files = make(map[string][]byte)
+	canary.FileMeta = make(map[string]FileMeta)
+	filesDir := filepath.Join(dir, "files")
+	err = filepath.Walk(filesDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		relpath, err := filepath.Rel(filesDir, path)
+		if err != nil {
+			return err
+		}
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		canary.Files[filepath.ToSlash(relpath)] = contents
+		if meta := fileMetaFor(info, contents); meta != (FileMeta{}) {
+			canary.FileMeta[filepath.ToSlash(relpath)] = meta
+		}
+		return nil
+	})
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	return canary, nil
+}
+
+// check compares a report card with the expected one. They must agree on
+// whether the run passed, and every expected result must be present with
+// the same outcome.
+func (canary *ProblemTypeCanary) check(actual *ReportCard) error {
+	if actual.Passed != canary.Expected.Passed {
+		return fmt.Errorf("expected passed=%v but got passed=%v: %s", canary.Expected.Passed, actual.Passed, actual.Note)
+	}
+	outcomes := make(map[string]string)
+	for _, elt := range actual.Results {
+		outcomes[elt.Name] = elt.Outcome
+	}
+	for _, elt := range canary.Expected.Results {
+		outcome, found := outcomes[elt.Name]
+		if !found {
+			return fmt.Errorf("expected a result for %s but found none", elt.Name)
+		}
+		if outcome != elt.Outcome {
+			return fmt.Errorf("expected %s to be %s but it was %s", elt.Name, elt.Outcome, outcome)
+		}
+	}
+	return nil
+}
+
+// canaryResults holds the latest canary result for each problem type
+// this daycare serves.
+type canaryResults struct {
+	sync.Mutex
+	results map[string]*CanaryResult
+}
+
+var canaries = &canaryResults{results: make(map[string]*CanaryResult)}
+
+// Passing reports whether a problem type has passed its latest canary run.
+// Problem types are withheld until their first canary has run.
+func (c *canaryResults) Passing(name string) bool {
+	c.Lock()
+	defer c.Unlock()
+	result := c.results[name]
+	return result != nil && result.Passed
+}
+
+func (c *canaryResults) set(result *CanaryResult) {
+	c.Lock()
+	defer c.Unlock()
+	old := c.results[result.ProblemType]
+	switch {
+	case !result.Passed && (old == nil || old.Passed):
+		log.Printf("canary for problem type %s failed, withholding it: %s", result.ProblemType, result.Note)
+	case result.Passed && old != nil && !old.Passed:
+		log.Printf("canary for problem type %s passed again", result.ProblemType)
+	}
+	c.results[result.ProblemType] = result
+}
+
+// List returns the latest results sorted by problem type.
+func (c *canaryResults) List() []*CanaryResult {
+	c.Lock()
+	defer c.Unlock()
+	list := make([]*CanaryResult, 0, len(c.results))
+	for _, elt := range c.results {
+		list = append(list, elt)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ProblemType < list[j].ProblemType })
+	return list
+}
+
+// GetCanaries handles a request to /canaries on a daycare,
+// returning the latest canary result for each problem type.
+func GetCanaries(w http.ResponseWriter) {
+	raw, err := json.MarshalIndent(canaries.List(), "", "    ")
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "json error: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(raw)
+}
+
+// canaryRunner runs the canary for every problem type this daycare serves,
+// first at startup and then on a schedule. A failed canary is retried sooner.
+func canaryRunner(interval time.Duration) {
+	for {
+		allPassed := true
+		for _, name := range Config.ProblemTypes {
+			select {
+			case <-drain.Draining():
+				return
+			default:
+			}
+			result := runCanary(name)
+			canaries.set(result)
+			allPassed = allPassed && result.Passed
+		}
+
+		wait := interval
+		if !allPassed && canaryRetryInterval < wait {
+			wait = canaryRetryInterval
+		}
+		select {
+		case <-time.After(wait):
+		case <-drain.Draining():
+			return
+		}
+	}
+}
+
+// runCanary fetches the canary for a problem type from the TA and runs it.
+func runCanary(name string) *CanaryResult {
+	start := time.Now()
+	result := &CanaryResult{ProblemType: name, Time: start}
+	fail := func(format string, args ...interface{}) *CanaryResult {
+		result.Note = fmt.Sprintf(format, args...)
+		result.Duration = time.Since(start)
+		return result
+	}
+
+	bundle, err := fetchCanary(name)
+	if err != nil {
+		return fail("fetching canary: %v", err)
+	}
+	if bundle == nil {
+		result.Passed = true
+		result.Note = "no canary"
+		return result
+	}
+	problemType, canary := bundle.ProblemType, bundle.Canary
+	result.Action = canary.Action
+	action := problemType.Actions[canary.Action]
+	if action == nil {
+		return fail("problem type has no %s action", canary.Action)
+	}
+
+	// wait for a container slot like any other run
+	ticket, err := containerLimiter.Enqueue()
+	if err != nil {
+		return fail("%v", err)
+	}
+	<-ticket.ready
+	admitted := time.Now()
+	defer func() {
+		containerLimiter.Release(time.Since(admitted))
+	}()
+
+	problem := &Problem{Unique: "canary", Note: "canary for " + name}
+	n, err := NewNanny(problemType, problem, action, nil, newLimits(action), "canary-"+name)
+	if err != nil {
+		return fail("creating container: %v", err)
+	}
+	n.ReportCard.CountSkipped = CountSkippedFor(nil)
+	defer func() {
+		if err := n.Shutdown("canary finished"); err != nil {
+			log.Printf("%v", err)
+		}
+	}()
+
+	// the transcript is not needed
+	eventsDone := make(chan struct{})
+	go func() {
+		for range n.Events {
+		}
+		close(eventsDone)
+	}()
+
+	files := make(map[string][]byte)
+	fileMeta := make(map[string]FileMeta)
+	for name, contents := range problemType.Files {
+		files[name] = contents
+		fileMeta[name] = problemType.FileMeta[name]
+	}
+	for name, contents := range canary.Files {
+		files[name] = contents
+		fileMeta[name] = canary.FileMeta[name]
+	}
+	if err := n.PutFiles(files, fileMeta); err != nil {
+		close(n.Events)
+		<-eventsDone
+		return fail("uploading files: %v", err)
+	}
+	runAction(n, action)
+	close(n.Events)
+	<-eventsDone
+
+	result.ReportCard = n.ReportCard
+	if err := canary.check(n.ReportCard); err != nil {
+		return fail("%v", err)
+	}
+	result.Passed = true
+	result.Duration = time.Since(start)
+	return result
+}
+
+// fetchCanary gets the canary for a problem type from the TA.
+// It returns nil if the problem type has none.
+func fetchCanary(name string) (*CanaryBundle, error) {
+	res, err := daycareGet("/daycare_canaries/"+name, name)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, fmt.Errorf("unexpected status %s: %s", res.Status, body)
+	}
+	bundle := new(CanaryBundle)
+	if err := json.NewDecoder(res.Body).Decode(bundle); err != nil {
+		return nil, fmt.Errorf("decoding canary: %v", err)
+	}
+	if bundle.ProblemType == nil || bundle.Canary == nil {
+		return nil, fmt.Errorf("incomplete canary bundle")
+	}
+	return bundle, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupUsage reads resource usage from the cgroup of the process with the
+// given PID (normally a container's main process). It supports cgroup v2
+// and falls back to the v1 controllers. It also returns the number of times
+// the OOM killer has fired in the cgroup.
+func cgroupUsage(pid int) (*ResourceUsage, int64, error) {
+	raw, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/cgroup", pid))
+	if err != nil {
+		return nil, 0, fmt.Errorf("finding cgroup: %v", err)
+	}
+
+	// lines have the form hierarchy-ID:controller-list:path
+	paths := make(map[string]string)
+	for _, line := range strings.Split(strings.TrimSpace(string(raw)), "\n") {
+		parts := strings.SplitN(line, ":", 3)
+		if len(parts) != 3 {
+			continue
+		}
+		if parts[0] == "0" && parts[1] == "" {
+			paths[""] = parts[2]
+			continue
+		}
+		for _, controller := range strings.Split(parts[1], ",") {
+			paths[controller] = parts[2]
+		}
+	}
+
+	usage := new(ResourceUsage)
+	var oomKills int64
+	if path, ok := paths[""]; ok && len(paths) == 1 {
+		// cgroup v2: everything is in one directory
+		dir := filepath.Join(cgroupRoot, path)
+		if usec, err := readKeyedValue(filepath.Join(dir, "cpu.stat"), "usage_usec"); err == nil {
+			usage.CPUTime = time.Duration(usec) * time.Microsecond
+		}
+		if peak, err := readSingleValue(filepath.Join(dir, "memory.peak")); err == nil {
+			usage.PeakMemory = peak
+		}
+		if peak, err := readSingleValue(filepath.Join(dir, "pids.peak")); err == nil {
+			usage.PeakPids = peak
+		}
+		if kills, err := readKeyedValue(filepath.Join(dir, "memory.events"), "oom_kill"); err == nil {
+			oomKills = kills
+		}
+	} else {
+		// cgroup v1: each controller has its own hierarchy
+		if path, ok := paths["cpuacct"]; ok {
+			if nsec, err := readSingleValue(filepath.Join(cgroupRoot, "cpuacct", path, "cpuacct.usage")); err == nil {
+				usage.CPUTime = time.Duration(nsec)
+			}
+		}
+		if path, ok := paths["memory"]; ok {
+			dir := filepath.Join(cgroupRoot, "memory", path)
+			if peak, err := readSingleValue(filepath.Join(dir, "memory.max_usage_in_bytes")); err == nil {
+				usage.PeakMemory = peak
+			}
+			if kills, err := readKeyedValue(filepath.Join(dir, "memory.oom_control"), "oom_kill"); err == nil {
+				oomKills = kills
+			}
+		}
+	}
+	usage.OOMKilled = oomKills > 0
+	return usage, oomKills, nil
+}
+
+// readSingleValue reads a file holding a single integer.
+func readSingleValue(path string) (int64, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(string(bytes.TrimSpace(raw)), 10, 64)
+}
+
+// readKeyedValue finds a "key value" line in a file and returns the value.
+func readKeyedValue(path, key string) (int64, error) {
+	fp, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer fp.Close()
+	scanner := bufio.NewScanner(fp)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 && fields[0] == key {
+			return strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	return 0, fmt.Errorf("%s not found in %s", key, path)
+}
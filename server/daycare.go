@@ -7,10 +7,12 @@ import (
 	"io"
 	"log"
 	"net/http"
-	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/go-martini/martini"
@@ -18,12 +20,16 @@ import (
 	. "github.com/russross/codegrinder/types"
 )
 
-// containerEngine defines the command-line executable to use for container management.
-const containerEngine = "docker"
-
 // studentUID defines the static user and group ID to be used inside containers.
 const studentUID = 1001
 
+// sessionGracePeriod is added to the session time limit for the container's
+// main process, so the nanny's own session timer normally fires first.
+const sessionGracePeriod = 10 * time.Second
+
+// maxTerminalDimension bounds the rows and columns a client may request.
+const maxTerminalDimension = 1000
+
 type limits struct {
 	maxCPU      int64
 	maxSession  int64
@@ -46,48 +52,46 @@ func newLimits(t *ProblemTypeAction) *limits {
 	}
 }
 
-func (l *limits) override(options []string) {
-	for _, elt := range options {
-		parts := strings.Split(elt, "=")
-		if len(parts) != 2 {
-			continue
-		}
-		val, err := strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 63)
-		if err != nil {
-			continue
-		}
-		switch strings.TrimSpace(parts[0]) {
+func (l *limits) override(options map[string]*OptionValue) {
+	for name, value := range options {
+		switch name {
 		case "maxCPU":
-			l.maxCPU = val
+			l.maxCPU = value.Int
 		case "maxSession":
-			l.maxSession = val
+			l.maxSession = value.Int
 		case "maxTimeout":
-			l.maxTimeout = val
+			l.maxTimeout = value.Int
 		case "maxFD":
-			l.maxFD = val
+			l.maxFD = value.Int
 		case "maxFileSize":
-			l.maxFileSize = val
+			l.maxFileSize = value.Int
 		case "maxMemory":
-			l.maxMemory = val
+			l.maxMemory = value.Int
 		case "maxThreads":
-			l.maxThreads = val
+			l.maxThreads = value.Int
 		}
 	}
 }
 
-var containerLimiter chan struct{}
-
 // SocketProblemTypeAction handles a request to /sockets/:problem_type/:action
 // It expects a websocket connection, which will receive a series of DaycareRequest objects
 // and will respond with DaycareResponse objects, though not in a one-to-one fashion.
 // The first DaycareRequest must have the CommitBundle field present. Future requests
-// should only have Stdin present.
+// should only have Stdin and/or CloseStdin present, and are only accepted for
+// interactive actions.
 func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params martini.Params) {
 	now := time.Now()
 
 	// CORS header for browser-based requests if the TA is a different host than the daycare
 	w.Header().Set("Access-Control-Allow-Origin", "https://"+Config.TAHostname)
 
+	// refuse new sessions while shutting down
+	if !drain.Enter() {
+		loggedHTTPErrorf(w, http.StatusServiceUnavailable, "this daycare is shutting down, please try again")
+		return
+	}
+	defer drain.Leave()
+
 	// get a websocket
 	socket, err := websocket.Upgrade(w, r, nil, 1024, 1024)
 	if err != nil {
@@ -238,37 +242,69 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		return
 	}
 	if step.ProblemType != problemType.Name {
-		logAndTransmitErrorf("step number %d in the problem has problem type %q but the commit bundle included problem type %q", step.ProblemType, problemType)
+		logAndTransmitErrorf("step number %d in the problem has problem type %q but the commit bundle included problem type %q", commit.Step, step.ProblemType, problemType.Name)
 		return
 	}
 
 	// collect the files from the problem step, commit, and problem type
 	files := make(map[string][]byte)
+	fileMeta := make(map[string]FileMeta)
 	for name, contents := range step.Files {
 		files[name] = contents
+		fileMeta[name] = step.FileMeta[name]
 	}
 	for name, contents := range commit.Files {
 		files[name] = contents
+		fileMeta[name] = commit.FileMeta[name]
 	}
 	for name, contents := range req.CommitBundle.ProblemType.Files {
 		files[name] = contents
+		fileMeta[name] = req.CommitBundle.ProblemType.FileMeta[name]
+	}
+	if err := ValidateFilePaths(files); err != nil {
+		logAndTransmitErrorf("%v", err)
+		return
+	}
+
+	// each student gets one run at a time on this daycare
+	run, err := activeUserRuns.Begin(req.CommitBundle.UserID, Config.ConcurrentRuns)
+	if err != nil {
+		logAndTransmitErrorf("%v", err)
+		return
+	}
+	defer activeUserRuns.End(req.CommitBundle.UserID, run)
+	if !waitForEarlierRuns(socket, run) {
+		return
 	}
 
 	// limit the number of concurrent containers
-	containerLimiter <- struct{}{}
+	ticket, err := containerLimiter.Enqueue()
+	if err != nil {
+		logAndTransmitErrorf("%v", err)
+		return
+	}
+	if !waitInQueue(socket, ticket, run) {
+		return
+	}
+	slotStart := time.Now()
 	defer func() {
-		<-containerLimiter
+		containerLimiter.Release(time.Since(slotStart))
 	}()
 
 	// launch a nanny process
 	nannyName := fmt.Sprintf("nanny-%d", req.CommitBundle.UserID)
+	options, errs := OptionsFor(problem.Options, action.Action)
+	for _, err := range errs {
+		log.Printf("ignoring option for problem %s: %v", problem.Unique, err)
+	}
 	limits := newLimits(action)
-	limits.override(problem.Options)
-	n, err := NewNanny(req.CommitBundle.ProblemType, problem, action.Action, args, limits, nannyName)
+	limits.override(options)
+	n, err := NewNanny(req.CommitBundle.ProblemType, problem, action, args, limits, nannyName)
 	if err != nil {
 		logAndTransmitErrorf("error creating container: %v", err)
 		return
 	}
+	n.ReportCard.CountSkipped = CountSkippedFor(options)
 
 	// shutdown the container when finished
 	defer func() {
@@ -277,6 +313,15 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		}
 	}()
 
+	// stop if the student starts another run
+	go func() {
+		select {
+		case <-run.cancel:
+			n.Cancel()
+		case <-n.done:
+		}
+	}()
+
 	// relay container events to the socket
 	eventListenerClosed := make(chan struct{})
 	go func() {
@@ -307,7 +352,7 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 
 			// transmit the message to the client
 			switch event.Event {
-			case "exec", "exit", "stdin", "stdout", "stderr", "stdinclosed", "error", "files":
+			case "exec", "exit", "stdin", "stdout", "stderr", "stdinclosed", "error", "files", "stage", "usage":
 				if event.Event == "files" {
 					log.Printf("%s", event)
 				}
@@ -335,49 +380,95 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 		eventListenerClosed <- struct{}{}
 	}()
 
+	// relay stdin from the socket to the container for interactive actions
+	if action.Interactive {
+		go func() {
+			defer n.CloseInput()
+			for {
+				req := new(DaycareRequest)
+				if err := socket.ReadJSON(req); err != nil {
+					// socket closed by the client or by the handler finishing
+					return
+				}
+				if req.CommitBundle != nil {
+					log.Printf("ignoring commit bundle in follow-up request message for %s", nannyName)
+				}
+				if req.Resize != nil {
+					n.Resize(req.Resize)
+				}
+				if len(req.Stdin) > 0 && !n.SendInput(string(req.Stdin)) {
+					return
+				}
+				if req.CloseStdin {
+					return
+				}
+			}
+		}()
+	}
+
 	// copy the files to the container
-	if err = n.PutFiles(files, 0666); err != nil {
+	if err = n.PutFiles(files, fileMeta); err != nil {
+		if n.Cancelled() {
+			n.reportCancelled()
+		}
 		n.ReportCard.LogAndFailf("uploading files: %v", err)
 		return
 	}
 
 	// run the action
-	cmd := strings.Fields(action.Command)
-	switch {
-	case action.Parser == "xunit":
-		runAndParseXUnit(n, cmd)
-
-	case action.Parser == "check":
-		runAndParseCheckXML(n, cmd)
+	if !runAction(n, action) {
+		return
+	}
 
-	case action.Parser != "":
-		n.ReportCard.LogAndFailf("unknown parser %q for problem type %s action %s",
-			action.Parser, action.ProblemType, action.Action)
+	// a cancelled run does not produce a commit
+	if n.Cancelled() {
+		n.reportCancelled()
+		close(n.Events)
+		<-eventListenerClosed
+		log.Printf("%s was cancelled by a newer run", nannyName)
 		return
+	}
 
-	default:
-		_, _, _, status, err := n.Exec(cmd)
+	// make sure a session timeout is reflected in the report card
+	// even if it did not interrupt a running command
+	if n.SessionExpired() && n.ReportCard.Passed {
+		n.ReportCard.LogAndFailf("killed: exceeded the session time limit of %v", n.sessionLimit)
+	}
+
+	// record the resources used
+	usage := n.Usage()
+	n.ReportCard.Usage = usage
+	n.Events <- &EventMessage{
+		Time:  time.Now(),
+		Event: "usage",
+		Usage: usage,
+	}
+
+	// weigh the test cases as the step directs
+	if contents, exists := step.Files[WeightsFile]; exists {
+		weights, err := ParseWeights(contents)
 		if err != nil {
-			n.ReportCard.LogAndFailf("%q exec error: %v", strings.Join(cmd, " "), err)
-		}
-		if status != 0 {
-			err := fmt.Errorf("%q failed with exit status %d", strings.Join(cmd, " "), status)
-			n.ReportCard.LogAndFailf("%v", err)
+			log.Printf("ignoring weights for problem %s step %d: %v", problem.Unique, step.Step, err)
+		} else {
+			n.ReportCard.ApplyWeights(weights)
 		}
 	}
 
 	commit.ReportCard = n.ReportCard
 
 	// download any files?
-	for _, option := range problem.Options {
-		parts := strings.SplitN(option, "=", 2)
-		if len(parts) != 2 || parts[0] != "download" {
-			continue
-		}
-		files, err := n.GetFiles(strings.Split(parts[1], ","))
+	if download := options["download"]; download != nil {
+		files, err := n.GetFiles(download.List)
 		if err != nil {
 			log.Printf("error trying to download files from container: %v", err)
-		} else if len(files) > 0 {
+		}
+		for name := range files {
+			if err := ValidateFilePath(name); err != nil {
+				log.Printf("not downloading file from container: %v", err)
+				delete(files, name)
+			}
+		}
+		if len(files) > 0 {
 			n.Events <- &EventMessage{Event: "files", Files: files}
 		}
 	}
@@ -397,13 +488,7 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 			commit.Score = 0.0
 		} else {
 			// compute partial credit for this step
-			passed := 0
-			for _, elt := range commit.ReportCard.Results {
-				if elt.Outcome == "passed" {
-					passed++
-				}
-			}
-			commit.Score = float64(passed) / float64(len(commit.ReportCard.Results))
+			commit.Score = commit.ReportCard.ComputeScore()
 		}
 		commit.UpdatedAt = now
 		req.CommitBundle.CommitSignature = commit.ComputeSignature(Config.DaycareSecret, req.CommitBundle.ProblemTypeSignature, req.CommitBundle.ProblemSignature, req.CommitBundle.Hostname, req.CommitBundle.UserID)
@@ -417,88 +502,391 @@ func SocketProblemTypeAction(w http.ResponseWriter, r *http.Request, params mart
 	log.Printf("handler for %s finished", nannyName)
 }
 
+// runAction runs an action's command or stages in the nanny's container,
+// recording the results in its report card with the location of each
+// failure and failing it for skipped tests if those count against the
+// run. It returns false without running anything if the action names an
+// unknown parser.
+func runAction(n *Nanny, action *ProblemTypeAction) bool {
+	if len(action.Stages) > 0 {
+		for _, stage := range action.Stages {
+			if !knownParser(stage.Parser) {
+				n.ReportCard.LogAndFailf("unknown parser %q for problem type %s action %s stage %s, must be one of %s",
+					stage.Parser, action.ProblemType, action.Action, stage.Name, parserNames())
+				return false
+			}
+		}
+		runStages(n, action.Stages)
+		locateResults(n.ReportCard)
+		n.ReportCard.CheckSkipped()
+		return true
+	}
+
+	if !knownParser(action.Parser) {
+		n.ReportCard.LogAndFailf("unknown parser %q for problem type %s action %s, must be one of %s",
+			action.Parser, action.ProblemType, action.Action, parserNames())
+		return false
+	}
+	runParsed(n, strings.Fields(action.Command), action.Parser)
+	locateResults(n.ReportCard)
+	n.ReportCard.CheckSkipped()
+	return true
+}
+
+// knownParser reports whether the named parser is registered.
+// The empty string means the exit status alone decides the outcome.
+func knownParser(parser string) bool {
+	_, found := parsers[parser]
+	return found
+}
+
+// runParsed runs a command and records its results in the nanny's
+// report card using the named parser.
+func runParsed(n *Nanny, cmd []string, parser string) {
+	parsers[parser](n, cmd)
+}
+
+// runStages runs the stages of a multi-stage action in order. Each stage
+// starts with a fresh report card that is merged into the nanny's when it
+// finishes. Once a stage fails with StopOnFailure set, or the run is cut
+// short, the remaining stages are recorded as skipped.
+func runStages(n *Nanny, stages []*ProblemTypeActionStage) {
+	overall, actionTimeout := n.ReportCard, n.execTimeout
+	defer func() {
+		n.ReportCard, n.execTimeout = overall, actionTimeout
+	}()
+
+	stopped := false
+	for _, stage := range stages {
+		if stopped {
+			overall.SkipStage(stage.Name)
+			continue
+		}
+		n.Events <- &EventMessage{
+			Time:  time.Now(),
+			Event: "stage",
+			Stage: stage.Name,
+		}
+
+		n.ReportCard, n.execTimeout = NewReportCard(), actionTimeout
+		if stage.MaxTimeout > 0 {
+			n.execTimeout = time.Duration(stage.MaxTimeout) * time.Second
+		}
+		start := time.Now()
+		runParsed(n, strings.Fields(stage.Command), stage.Parser)
+		n.ReportCard.AddTime(time.Since(start))
+		overall.AddStage(stage.Name, n.ReportCard)
+
+		if n.Cancelled() || n.SessionExpired() || (!n.ReportCard.Passed && stage.StopOnFailure) {
+			stopped = true
+		}
+	}
+}
+
+// waitInQueue blocks until the ticket is granted a container slot, sending
+// queued events to the client as it moves up in line. It returns false if
+// the client went away or the run was cancelled, in which case the ticket
+// has been cancelled.
+func waitInQueue(socket *websocket.Conn, ticket *queueTicket, run *userRun) bool {
+	ticker := time.NewTicker(queueUpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticket.ready:
+			return true
+		default:
+		}
+
+		if position, wait := containerLimiter.Position(ticket); position > 0 {
+			if err := sendQueued(socket, position, wait); err != nil {
+				log.Printf("client left the queue: %v", err)
+				containerLimiter.Cancel(ticket)
+				return false
+			}
+		}
+
+		select {
+		case <-ticket.ready:
+			return true
+		case <-run.cancel:
+			containerLimiter.Cancel(ticket)
+			sendCancelled(socket)
+			return false
+		case <-ticket.moved:
+		case <-ticker.C:
+		}
+	}
+}
+
+// waitForEarlierRuns blocks until any earlier runs by the same student have
+// finished, sending queued events to the client while it waits. It returns
+// false if the client went away or this run was cancelled.
+func waitForEarlierRuns(socket *websocket.Conn, run *userRun) bool {
+	select {
+	case <-run.Wait():
+		return true
+	default:
+	}
+
+	ticker := time.NewTicker(queueUpdateInterval)
+	defer ticker.Stop()
+	for {
+		if err := sendQueued(socket, 1, 0); err != nil {
+			log.Printf("client left while waiting for an earlier run: %v", err)
+			return false
+		}
+		select {
+		case <-run.Wait():
+			return true
+		case <-run.cancel:
+			sendCancelled(socket)
+			return false
+		case <-ticker.C:
+		}
+	}
+}
+
+func sendQueued(socket *websocket.Conn, position int, wait time.Duration) error {
+	res := &DaycareResponse{
+		Event: &EventMessage{
+			Time:          time.Now(),
+			Event:         "queued",
+			QueuePosition: position,
+			QueueWait:     wait,
+		},
+	}
+	return socket.WriteJSON(res)
+}
+
+func sendCancelled(socket *websocket.Conn) {
+	log.Printf("%v", errCancelled)
+	res := &DaycareResponse{
+		Event: &EventMessage{
+			Time:  time.Now(),
+			Event: "error",
+			Error: errCancelled.Error(),
+		},
+	}
+	if err := socket.WriteJSON(res); err != nil {
+		// the client may already be gone
+	}
+}
+
 type Nanny struct {
-	Name       string
-	Start      time.Time
-	ID         string
-	ReportCard *ReportCard
-	Input      chan string
-	Events     chan *EventMessage
-	Transcript []*EventMessage
-	Closed     bool
-	Files      map[string][]byte
-}
-
-func NewNanny(problemType *ProblemType, problem *Problem, action string, args []string, limits *limits, name string) (*Nanny, error) {
-	disk := limits.maxFileSize * 1024 * 1024
-	timeLimit := limits.maxCPU * 2
-	userAndGroup := fmt.Sprintf("%d:%d", studentUID, studentUID)
-	memStr := fmt.Sprintf("%dm", limits.maxMemory)
-
-	// construct the 'docker run' command arguments
-	cmdArgs := []string{
-		"run",
-		"-d", // detached mode.
-		"--name", name,
-		"--hostname", name,
-		"--user", userAndGroup,
-		"--net=none",
-
-		// cgroup-based resource limits.
-		"--memory", memStr,
-		"--memory-swap", memStr, // prevent swapping
-		"--pids-limit", strconv.FormatInt(limits.maxThreads, 10),
-
-		// security hardening flags.
-		"--cap-drop", "ALL",
-		"--security-opt", "no-new-privileges", // prevent privilege escalation
-		//"--security-opt", "seccomp=default",   // apply default syscall filter
-
-		// ulimits for resources not covered by cgroups.
-		// note: --pids-limit makes nproc redundant
-		// note: nofile is less critical with modern kernels
-		"--ulimit", fmt.Sprintf("core=0:0"),
-		"--ulimit", fmt.Sprintf("cpu=%d", limits.maxCPU),
-		"--ulimit", fmt.Sprintf("fsize=%d", disk),
-	}
-
-	// main command just sleeps; this acts as a timeout mechanism for the whole container
-	cmdArgs = append(cmdArgs, problemType.Image, "/bin/sleep", strconv.FormatInt(timeLimit, 10)+"s")
-
-	log.Printf("new container %s; action %s on %s (%s); params cpu=%d, fd=%d, file=%d, mem=%d, threads=%d",
-		name, action, problem.Unique, problemType.Name,
-		limits.maxCPU, limits.maxFD, limits.maxFileSize, limits.maxMemory, limits.maxThreads)
-
-	// execute the command.
-	cmd := exec.Command(containerEngine, cmdArgs...)
-	output, err := cmd.CombinedOutput()
+	Name        string
+	Start       time.Time
+	ID          string
+	ReportCard  *ReportCard
+	Interactive bool
+	PTY         bool
+	Input       chan string
+	Events      chan *EventMessage
+	Transcript  []*EventMessage
+	Closed      bool
+	Files       map[string][]byte
+
+	engine       ContainerEngine
+	pool         *containerPool
+	network      string
+	sidecarID    string
+	readOnlyRoot bool
+	releaseCache func()
+	done         chan struct{}
+	inputClosed  sync.Once
+
+	execTimeout    time.Duration
+	sessionLimit   time.Duration
+	cpuLimit       int64
+	memoryLimit    int64
+	fileSizeLimit  int64
+	oomKills       int64
+	sessionTimer   *time.Timer
+	sessionExpired int32
+	cancelled      int32
+	cancelNotice   sync.Once
+
+	resizeLock   sync.Mutex
+	terminalSize *TerminalSize
+	resize       chan TerminalSize
+}
+
+// containerSpecFor describes the container for an action, apart from any
+// network. The warm pool builds the same spec ahead of time, so anything
+// that varies from one run to the next does not belong here.
+func containerSpecFor(problemType *ProblemType, action *ProblemTypeAction, limits *limits, name string) (*ContainerSpec, error) {
+	timeLimit := time.Duration(limits.maxCPU*2) * time.Second
+	if limits.maxSession > 0 {
+		timeLimit = time.Duration(limits.maxSession)*time.Second + sessionGracePeriod
+	}
+	spec := &ContainerSpec{
+		Name:  name,
+		Image: problemType.Image,
+
+		// main command just sleeps; this acts as a backstop timeout for the whole container
+		// in case the nanny is not around to enforce the session time limit
+		Command: []string{"/bin/sleep", strconv.FormatInt(int64(timeLimit/time.Second), 10) + "s"},
+
+		UID:         studentUID,
+		MaxCPU:      limits.maxCPU,
+		MaxFD:       limits.maxFD,
+		MaxFileSize: limits.maxFileSize,
+		MaxMemory:   limits.maxMemory,
+		MaxThreads:  limits.maxThreads,
+		Labels:      map[string]string{containerLabel: Config.Hostname},
+	}
+
+	// run the exact image the problem type was pinned to
+	if problemType.ImageDigest != "" {
+		spec.Image = problemType.ImageDigest
+	}
+
+	// harden the sandbox as the problem type requires
+	if problemType.Runtime != "" {
+		runtime := Config.Runtimes[problemType.Runtime]
+		if runtime == "" {
+			return nil, fmt.Errorf("runtime %q is not available on this daycare", problemType.Runtime)
+		}
+		spec.Runtime = runtime
+	}
+	if problemType.SeccompProfile != "" {
+		profile, exists := problemType.Files[problemType.SeccompProfile]
+		if !exists {
+			return nil, fmt.Errorf("seccomp profile %s is missing from the files for problem type %s", problemType.SeccompProfile, problemType.Name)
+		}
+		spec.SeccompProfile = profile
+	}
+	if problemType.HomeSize > 0 {
+		spec.ReadOnlyRootfs = true
+		spec.Tmpfs = map[string]string{
+			"/home/student": fmt.Sprintf("rw,exec,size=%dm,mode=0755,uid=%d,gid=%d", problemType.HomeSize, studentUID, studentUID),
+			"/tmp":          fmt.Sprintf("rw,exec,nosuid,size=%dm,mode=1777", problemType.HomeSize),
+		}
+	}
+
+	// mount the dependency cache, writable only by a warmup action
+	if problemType.CachePath != "" {
+		spec.Volumes = []VolumeMount{{
+			Volume:   cacheVolume(problemType.Name),
+			Target:   problemType.CachePath,
+			ReadOnly: !action.Warmup,
+		}}
+	}
+	return spec, nil
+}
+
+func NewNanny(problemType *ProblemType, problem *Problem, action *ProblemTypeAction, args []string, limits *limits, name string) (*Nanny, error) {
+	spec, err := containerSpecFor(problemType, action, limits, name)
 	if err != nil {
-		// if the container already exists, try to remove it and retry
-		// this prevents a single student running multiple graders concurrently
-		if strings.Contains(string(output), "is already in use") {
+		return nil, err
+	}
+
+	log.Printf("new container %s; action %s on %s (%s); params cpu=%d, session=%d, timeout=%d, fd=%d, file=%d, mem=%d, threads=%d",
+		name, action.Action, problem.Unique, problemType.Name,
+		limits.maxCPU, limits.maxSession, limits.maxTimeout, limits.maxFD, limits.maxFileSize, limits.maxMemory, limits.maxThreads)
+
+	engine := containerEngine
+
+	// give the container a network of its own if the problem asks for one
+	// invalid options were already reported by the caller
+	options, _ := OptionsFor(problem.Options, action.Action)
+	netMode, sidecarName := NetworkFor(options)
+	var sidecarSpec *ContainerSpec
+	if netMode == NetworkSidecar {
+		image := Config.NetworkSidecars[sidecarName]
+		if image == "" {
+			return nil, fmt.Errorf("sidecar %q is not available on this daycare", sidecarName)
+		}
+		sidecarSpec = &ContainerSpec{
+			Name:           name + "-sidecar",
+			Image:          image,
+			MaxFD:          limits.maxFD,
+			MaxMemory:      limits.maxMemory,
+			MaxThreads:     limits.maxThreads,
+			Labels:         spec.Labels,
+			NetworkAliases: []string{sidecarName},
+		}
+	}
+	if netMode != NetworkNone {
+		spec.Network = name + "-net"
+		if err := engine.CreateNetwork(spec.Network, spec.Labels); err != nil {
+			return nil, fmt.Errorf("creating container network: %v", err)
+		}
+	}
+	var sidecarID string
+	if sidecarSpec != nil {
+		sidecarSpec.Network = spec.Network
+		if sidecarID, err = createContainer(engine, sidecarSpec); err != nil {
+			removeNetwork(engine, spec.Network)
+			return nil, fmt.Errorf("sidecar run failed: %v", err)
+		}
+	}
+
+	// only a warmup action may write to the dependency cache
+	releaseCache := func() {}
+	if problemType.CachePath != "" {
+		releaseCache = caches.Claim(problemType.Name, action.Warmup)
+	}
+
+	var containerID string
+	if warmPool != nil {
+		containerID = warmPool.acquire(problemType.Name, spec)
+	}
+	if containerID != "" {
+		// take over a warm container, keeping the per-user name
+		err = engine.Rename(containerID, name)
+		if err == errContainerExists {
 			log.Printf("killing existing container with same name %s", name)
-			if err2 := removeContainer(name); err2 != nil {
-				return nil, err2
+			if err = engine.Remove(name); err == nil {
+				err = engine.Rename(containerID, name)
 			}
-
-			// retry the command
-			output, err = exec.Command(containerEngine, cmdArgs...).CombinedOutput()
 		}
 		if err != nil {
-			return nil, fmt.Errorf("container run failed: %v\nOutput: %s", err, string(output))
+			engine.Remove(containerID)
+		}
+	} else {
+		containerID, err = createContainer(engine, spec)
+	}
+	if err != nil {
+		if warmPool != nil {
+			warmPool.release()
+		}
+		releaseCache()
+		if sidecarID != "" {
+			engine.Remove(sidecarID)
 		}
+		removeNetwork(engine, spec.Network)
+		return nil, fmt.Errorf("container run failed: %v", err)
 	}
 
-	containerID := strings.TrimSpace(string(output))
+	n := &Nanny{
+		Name:          name,
+		Start:         time.Now(),
+		ID:            containerID,
+		ReportCard:    NewReportCard(),
+		engine:        engine,
+		pool:          warmPool,
+		Interactive:   action.Interactive,
+		PTY:           action.Interactive && action.PTY,
+		Input:         make(chan string),
+		Events:        make(chan *EventMessage),
+		network:       spec.Network,
+		sidecarID:     sidecarID,
+		readOnlyRoot:  spec.ReadOnlyRootfs,
+		releaseCache:  releaseCache,
+		done:          make(chan struct{}),
+		execTimeout:   time.Duration(limits.maxTimeout) * time.Second,
+		sessionLimit:  time.Duration(limits.maxSession) * time.Second,
+		cpuLimit:      limits.maxCPU,
+		memoryLimit:   limits.maxMemory,
+		fileSizeLimit: limits.maxFileSize,
+	}
+
+	// enforce the session time limit
+	if n.sessionLimit > 0 {
+		n.sessionTimer = time.AfterFunc(n.sessionLimit, n.expireSession)
+	}
 
-	return &Nanny{
-		Name:       name,
-		Start:      time.Now(),
-		ID:         containerID,
-		ReportCard: NewReportCard(),
-		Input:      make(chan string),
-		Events:     make(chan *EventMessage),
-	}, nil
+	return n, nil
 }
 
 func (n *Nanny) Shutdown(msg string) error {
@@ -506,30 +894,161 @@ func (n *Nanny) Shutdown(msg string) error {
 		return nil
 	}
 	n.Closed = true
+	close(n.done)
+	if n.sessionTimer != nil {
+		n.sessionTimer.Stop()
+	}
 
-	// shut down the container
-	if err := removeContainer(n.ID); err != nil {
+	// shut down the container and let the pool replace it
+	err := n.engine.Remove(n.ID)
+	if n.pool != nil {
+		n.pool.release()
+	}
+	if n.sidecarID != "" {
+		if err := n.engine.Remove(n.sidecarID); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+	removeNetwork(n.engine, n.network)
+	n.releaseCache()
+	if err != nil {
 		return fmt.Errorf("Nanny.Shutdown: %v", err)
 	}
 	return nil
 }
 
-// removeContainer forcefully stops and removes a container by its ID or name.
-func removeContainer(id string) error {
-	cmd := exec.Command(containerEngine, "rm", "-f", id)
-	if err := cmd.Run(); err != nil {
-		return fmt.Errorf("error killing container %s: %v", id, err)
+// createContainer starts a container. If one with the same name already
+// exists it is a leftover, since runs by the same student are serialized,
+// so it is removed and creation is retried.
+func createContainer(engine ContainerEngine, spec *ContainerSpec) (string, error) {
+	id, err := engine.Create(spec)
+	if err == errContainerExists {
+		log.Printf("killing existing container with same name %s", spec.Name)
+		if err = engine.Remove(spec.Name); err == nil {
+			id, err = engine.Create(spec)
+		}
+	}
+	return id, err
+}
+
+// removeNetwork removes a per-container network, if there is one.
+// Failures are logged; the reaper prunes anything left behind.
+func removeNetwork(engine ContainerEngine, network string) {
+	if network == "" {
+		return
+	}
+	if err := engine.RemoveNetwork(network); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// expireSession kills the container when the session time limit is reached.
+// The container is not removed so Shutdown can clean up as usual.
+func (n *Nanny) expireSession() {
+	atomic.StoreInt32(&n.sessionExpired, 1)
+	log.Printf("container %s exceeded the session time limit of %v, killing it", n.Name, n.sessionLimit)
+	if err := n.engine.Kill(n.ID); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// Cancel kills the container because the student started another run.
+// The container is not removed so Shutdown can clean up as usual.
+func (n *Nanny) Cancel() {
+	atomic.StoreInt32(&n.cancelled, 1)
+	log.Printf("container %s cancelled by a newer run, killing it", n.Name)
+	if err := n.engine.Kill(n.ID); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// Cancelled reports whether the container was killed by Cancel.
+func (n *Nanny) Cancelled() bool {
+	return atomic.LoadInt32(&n.cancelled) != 0
+}
+
+// reportCancelled sends an error event explaining the cancellation,
+// but only the first time it is called.
+func (n *Nanny) reportCancelled() {
+	n.cancelNotice.Do(func() {
+		n.Events <- &EventMessage{
+			Time:  time.Now(),
+			Event: "error",
+			Error: errCancelled.Error(),
+		}
+	})
+}
+
+// SessionExpired reports whether the container was killed for exceeding
+// the session time limit.
+func (n *Nanny) SessionExpired() bool {
+	return atomic.LoadInt32(&n.sessionExpired) != 0
+}
+
+// oomKilled reports whether the kernel OOM killer has fired in the container
+// since the last time it was checked.
+func (n *Nanny) oomKilled() bool {
+	state, err := n.engine.State(n.ID)
+	if err != nil {
+		log.Printf("error checking state of container %s: %v", n.Name, err)
+		return false
+	}
+
+	// the cgroup counts kills, so it can tell which command was killed
+	if state.Pid > 0 {
+		if _, kills, err := cgroupUsage(state.Pid); err == nil {
+			killed := kills > n.oomKills
+			n.oomKills = kills
+			return killed
+		}
+	}
+	return state.OOMKilled
+}
+
+// Usage measures the resources used by the container so far.
+func (n *Nanny) Usage() *ResourceUsage {
+	usage := new(ResourceUsage)
+	state, err := n.engine.State(n.ID)
+	if err != nil {
+		log.Printf("error checking state of container %s: %v", n.Name, err)
+	} else {
+		if state.Pid > 0 {
+			if measured, _, err := cgroupUsage(state.Pid); err != nil {
+				log.Printf("error measuring resource usage of container %s: %v", n.Name, err)
+			} else {
+				usage = measured
+			}
+		}
+		if state.OOMKilled {
+			usage.OOMKilled = true
+		}
+	}
+	usage.WallTime = time.Since(n.Start)
+	return usage
+}
+
+// killProcesses kills every student process in the container,
+// leaving the container itself running.
+func (n *Nanny) killProcesses() {
+	// kill -1 signals everything except PID 1 (the sleep process) and the shell itself
+	var output bytes.Buffer
+	opts := &ExecOptions{UID: studentUID, Stdout: &output, Stderr: &output}
+	if _, err := n.engine.Exec(n.ID, []string{"/bin/sh", "-c", "kill -KILL -1"}, opts); err != nil {
+		log.Printf("error killing processes in container %s: %v\nOutput: %s", n.Name, err, output.String())
 	}
-	return nil
 }
 
 // copy a set of files to the given container
-// by streaming a tarball to the 'docker cp' command
+// by streaming a tarball to the container engine
+// files are executable if their metadata says so
 // note: the container must be running
-func (n *Nanny) PutFiles(files map[string][]byte, mode int64) error {
+func (n *Nanny) PutFiles(files map[string][]byte, fileMeta map[string]FileMeta) error {
 	if len(files) == 0 {
 		return nil
 	}
+	if err := ValidateFilePaths(files); err != nil {
+		return err
+	}
 
 	// create a tar archive in memory
 	nowish := time.Now().Add(-time.Second)
@@ -558,7 +1077,7 @@ func (n *Nanny) PutFiles(files map[string][]byte, mode int64) error {
 		}
 		header := &tar.Header{
 			Name:       name,
-			Mode:       mode,
+			Mode:       fileMeta[name].Mode(),
 			Uid:        studentUID,
 			Gid:        studentUID,
 			Size:       int64(len(contents)),
@@ -580,20 +1099,17 @@ func (n *Nanny) PutFiles(files map[string][]byte, mode int64) error {
 		return fmt.Errorf("closing tar file: %v", err)
 	}
 
-	// use 'docker cp' to copy the tarball into the /home/student directory.
-	// pipe the tar buffer to the command's stdin.
-	cmd := exec.Command(containerEngine, "cp", "-", n.ID+":/home/student/")
-	cmd.Stdin = buf
-
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("container cp failed: %v\nOutput: %s", err, string(output))
+	// copy the tarball into the /home/student directory
+	if n.readOnlyRoot {
+		return putFilesWithTar(n.engine, n.ID, "/home/student/", studentUID, buf)
 	}
-	return nil
+	return n.engine.PutFiles(n.ID, "/home/student/", buf)
 }
 
 // GetFiles copies files from the given container.
 // All student files are copied from the container on the first call to GetFiles.
-// Subsequent calls will gather files from the cached collection.
+// Subsequent calls will gather files from the cached collection until the
+// next command runs, since it may change them.
 func (n *Nanny) GetFiles(filenames []string) (map[string][]byte, error) {
 	if len(filenames) == 0 {
 		return nil, nil
@@ -606,22 +1122,22 @@ func (n *Nanny) GetFiles(filenames []string) (map[string][]byte, error) {
 			return nil, fmt.Errorf("cannot fetch files, container is closed")
 		}
 
-		// use 'docker cp' to get the /home/student directory as a tar stream
-		cmd := exec.Command(containerEngine, "cp", n.ID+":/home/student/.", "-")
-		var tarFile bytes.Buffer
-		cmd.Stdout = &tarFile
-
-		// capture stderr in case of error
-		var stderr bytes.Buffer
-		cmd.Stderr = &stderr
-
-		if err := cmd.Run(); err != nil {
-			return nil, fmt.Errorf("container cp from container failed: %v\nOutput: %s", err, tarFile.String())
+		// get the /home/student directory as a tar stream
+		var tarFile io.ReadCloser
+		var err error
+		if n.readOnlyRoot {
+			tarFile, err = getFilesWithTar(n.engine, n.ID, "/home/student/.", studentUID)
+		} else {
+			tarFile, err = n.engine.GetFiles(n.ID, "/home/student/.")
 		}
+		if err != nil {
+			return nil, err
+		}
+		defer tarFile.Close()
 
 		// extract the files
 		n.Files = make(map[string][]byte)
-		reader := tar.NewReader(&tarFile)
+		reader := tar.NewReader(tarFile)
 		for {
 			header, err := reader.Next()
 			if err == io.EOF {
@@ -681,41 +1197,154 @@ func (ew *eventWriter) Write(p []byte) (int, error) {
 	return len(p), nil
 }
 
+// SendInput queues a chunk of stdin data for the running (or next) command.
+// It returns false if the nanny has shut down and the input was discarded.
+// SendInput and CloseInput must be called from a single goroutine.
+func (n *Nanny) SendInput(data string) bool {
+	select {
+	case n.Input <- data:
+		return true
+	case <-n.done:
+		return false
+	}
+}
+
+// CloseInput signals that no more stdin data is coming.
+// It is safe to call more than once.
+func (n *Nanny) CloseInput() {
+	n.inputClosed.Do(func() {
+		close(n.Input)
+	})
+}
+
+// Resize records a new terminal size from the client and passes it on
+// to the running command, if any. It is ignored if the action does not
+// run on a terminal.
+func (n *Nanny) Resize(size *TerminalSize) {
+	if !n.PTY || size.Rows <= 0 || size.Cols <= 0 || size.Rows > maxTerminalDimension || size.Cols > maxTerminalDimension {
+		return
+	}
+	n.resizeLock.Lock()
+	defer n.resizeLock.Unlock()
+	n.terminalSize = size
+	if n.resize != nil {
+		// only the latest size matters, so replace any pending one
+		select {
+		case <-n.resize:
+		default:
+		}
+		n.resize <- *size
+	}
+}
+
+// relayInput copies data from the Input channel to the stdin of a running
+// command until the input is closed or the command finishes.
+func (n *Nanny) relayInput(stdin io.WriteCloser, finished <-chan struct{}) {
+	defer stdin.Close()
+	for {
+		select {
+		case <-finished:
+			return
+		case data, ok := <-n.Input:
+			if !ok {
+				n.Events <- &EventMessage{
+					Time:  time.Now(),
+					Event: "stdinclosed",
+				}
+				return
+			}
+			n.Events <- &EventMessage{
+				Time:       time.Now(),
+				Event:      "stdin",
+				StreamData: []byte(data),
+			}
+			if _, err := io.WriteString(stdin, data); err != nil && err != io.ErrClosedPipe {
+				log.Printf("error writing to stdin of %s: %v", n.Name, err)
+			}
+		}
+	}
+}
+
 // Exec runs a command inside the container and captures its output
 func (n *Nanny) Exec(cmd []string) (stdout, stderr, script *bytes.Buffer, status int, err error) {
+	// the command may change the files, so fetch them again afterward
+	n.Files = nil
+
 	n.Events <- &EventMessage{
 		Time:        time.Now(),
 		Event:       "exec",
 		ExecCommand: cmd,
 	}
 
-	// construct the 'docker exec' command arguments.
-	execCmdArgs := []string{"exec", "--user", strconv.Itoa(studentUID), n.ID}
-	execCmdArgs = append(execCmdArgs, cmd...)
-	command := exec.Command(containerEngine, execCmdArgs...)
-
 	// buffers to capture the full output for return.
 	var stdoutBuf, stderrBuf, scriptBuf bytes.Buffer
 
 	// create writers that send events over the channel AND write to local buffers.
-	stdoutWriter := io.MultiWriter(&stdoutBuf, &scriptBuf, &eventWriter{event: "stdout", events: n.Events})
-	stderrWriter := io.MultiWriter(&stderrBuf, &scriptBuf, &eventWriter{event: "stderr", events: n.Events})
+	opts := &ExecOptions{
+		UID:    studentUID,
+		Stdout: io.MultiWriter(&stdoutBuf, &scriptBuf, &eventWriter{event: "stdout", events: n.Events}),
+		Stderr: io.MultiWriter(&stderrBuf, &scriptBuf, &eventWriter{event: "stderr", events: n.Events}),
+	}
 
-	command.Stdout = stdoutWriter
-	command.Stderr = stderrWriter
+	// run on a terminal if requested, starting at the client's window size
+	if n.PTY {
+		resize := make(chan TerminalSize, 1)
+		n.resizeLock.Lock()
+		if n.terminalSize != nil {
+			resize <- *n.terminalSize
+		}
+		n.resize = resize
+		n.resizeLock.Unlock()
+		defer func() {
+			n.resizeLock.Lock()
+			n.resize = nil
+			n.resizeLock.Unlock()
+		}()
+		opts.TTY = true
+		opts.Resize = resize
+	}
 
-	// start the command
-	err = command.Run()
+	// relay stdin for interactive actions
+	var relay sync.WaitGroup
+	finished := make(chan struct{})
+	var stdin *io.PipeReader
+	if n.Interactive {
+		var stdinWriter *io.PipeWriter
+		stdin, stdinWriter = io.Pipe()
+		opts.Stdin = stdin
+		relay.Add(1)
+		go func() {
+			n.relayInput(stdinWriter, finished)
+			relay.Done()
+		}()
+	}
 
-	exitCode := 0
-	if err != nil {
-		// try to extract the exit code from the error
-		if exitError, ok := err.(*exec.ExitError); ok {
-			exitCode = exitError.ExitCode()
-		} else {
-			// a different error occurred (e.g., command not found).
-			return &stdoutBuf, &stderrBuf, &scriptBuf, -1, fmt.Errorf("exec command failed: %v", err)
-		}
+	// enforce the wall-clock time limit for this command
+	var timedOut int32
+	var timer *time.Timer
+	if n.execTimeout > 0 {
+		timer = time.AfterFunc(n.execTimeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			log.Printf("command in container %s exceeded the time limit of %v, killing it", n.Name, n.execTimeout)
+			n.killProcesses()
+		})
+	}
+
+	// run the command
+	exitCode, err := n.engine.Exec(n.ID, cmd, opts)
+	if timer != nil {
+		timer.Stop()
+	}
+
+	// input the command never read would block the relay forever
+	if stdin != nil {
+		stdin.CloseWithError(io.ErrClosedPipe)
+	}
+	close(finished)
+	relay.Wait()
+
+	if err != nil && !n.SessionExpired() && !n.Cancelled() {
+		return &stdoutBuf, &stderrBuf, &scriptBuf, -1, err
 	}
 
 	n.Events <- &EventMessage{
@@ -724,5 +1353,33 @@ func (n *Nanny) Exec(cmd []string) (stdout, stderr, script *bytes.Buffer, status
 		ExitStatus: exitCode,
 	}
 
+	if n.Cancelled() {
+		n.reportCancelled()
+		return &stdoutBuf, &stderrBuf, &scriptBuf, exitCode, errCancelled
+	}
+
+	// report resource limits separately from crashes
+	var limitErr error
+	switch {
+	case exitCode != 0 && n.oomKilled():
+		limitErr = fmt.Errorf("killed: exceeded %d MB memory limit", n.memoryLimit)
+	case exitCode == 128+int(syscall.SIGXCPU):
+		limitErr = fmt.Errorf("killed: exceeded %d second CPU time limit", n.cpuLimit)
+	case exitCode == 128+int(syscall.SIGXFSZ):
+		limitErr = fmt.Errorf("killed: exceeded %d MB file size limit", n.fileSizeLimit)
+	case n.SessionExpired():
+		limitErr = fmt.Errorf("killed: exceeded the session time limit of %v", n.sessionLimit)
+	case atomic.LoadInt32(&timedOut) != 0:
+		limitErr = fmt.Errorf("killed: exceeded the time limit of %v", n.execTimeout)
+	}
+	if limitErr != nil {
+		n.Events <- &EventMessage{
+			Time:  time.Now(),
+			Event: "error",
+			Error: limitErr.Error(),
+		}
+		return &stdoutBuf, &stderrBuf, &scriptBuf, exitCode, limitErr
+	}
+
 	return &stdoutBuf, &stderrBuf, &scriptBuf, exitCode, nil
 }
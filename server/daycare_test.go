@@ -0,0 +1,261 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// fakeNanny is a nanny running in a container on a fake engine,
+// with its events collected in the background.
+type fakeNanny struct {
+	*Nanny
+	lock   sync.Mutex
+	events []*EventMessage
+}
+
+// newFakeNanny starts a nanny for an action on the given engine. It is
+// shut down when the test finishes.
+func newFakeNanny(t *testing.T, engine *fakeEngine, action *ProblemTypeAction) *fakeNanny {
+	t.Helper()
+	containerEngine = engine
+	problemType := &ProblemType{Name: "fake", Image: "codegrinder/fake", Actions: map[string]*ProblemTypeAction{action.Action: action}}
+	problem := &Problem{Unique: "fake-problem"}
+	n, err := NewNanny(problemType, problem, action, nil, newLimits(action), "nanny-test")
+	if err != nil {
+		t.Fatalf("NewNanny: %v", err)
+	}
+
+	f := &fakeNanny{Nanny: n}
+	collected := make(chan struct{})
+	go func() {
+		for event := range n.Events {
+			if event == nil {
+				continue
+			}
+			f.lock.Lock()
+			f.events = append(f.events, event)
+			f.lock.Unlock()
+		}
+		close(collected)
+	}()
+	t.Cleanup(func() {
+		if err := n.Shutdown("test finished"); err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+		close(n.Events)
+		<-collected
+	})
+	return f
+}
+
+// eventNames lists the events seen so far, in order.
+func (f *fakeNanny) eventNames() []string {
+	// once the collector takes this, it has recorded everything sent before
+	f.Events <- nil
+
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	var names []string
+	for _, event := range f.events {
+		names = append(names, event.Event)
+	}
+	return names
+}
+
+// execWithin runs a command, failing the test if it has not finished
+// within a few seconds.
+func execWithin(t *testing.T, n *fakeNanny, cmd []string) (stdout string, status int, err error) {
+	t.Helper()
+	type result struct {
+		stdout string
+		status int
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		stdout, _, _, status, err := n.Exec(cmd)
+		done <- result{stdout.String(), status, err}
+	}()
+	select {
+	case r := <-done:
+		return r.stdout, r.status, r.err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Exec(%q) did not return", cmd)
+		return "", 0, nil
+	}
+}
+
+func TestExecLateInput(t *testing.T) {
+	// the command exits without reading input that arrives while it runs
+	engine := newFakeEngine()
+	running, proceed := make(chan struct{}), make(chan struct{})
+	engine.Run = func(files map[string][]byte, cmd []string, opts *ExecOptions) int {
+		close(running)
+		<-proceed
+		return 0
+	}
+	n := newFakeNanny(t, engine, &ProblemTypeAction{Action: "run", Interactive: true})
+
+	go func() {
+		<-running
+		if !n.SendInput("too late\n") {
+			t.Errorf("SendInput reported the nanny was shut down")
+		}
+		close(proceed)
+	}()
+	if _, status, err := execWithin(t, n, []string{"true"}); err != nil || status != 0 {
+		t.Errorf("Exec returned status %d, error %v", status, err)
+	}
+}
+
+func TestNannyFiles(t *testing.T) {
+	engine := newFakeEngine()
+	engine.Run = func(files map[string][]byte, cmd []string, opts *ExecOptions) int {
+		files["/home/student/out.txt"] = []byte("output\n")
+		return 0
+	}
+	n := newFakeNanny(t, engine, &ProblemTypeAction{Action: "grade"})
+
+	files := map[string][]byte{
+		"main.c":        []byte("int main(void) { return 0; }\n"),
+		"tests/run.sh":  []byte("#!/bin/sh\n"),
+		"tests/data.in": []byte("1 2 3\n"),
+	}
+	meta := map[string]FileMeta{"tests/run.sh": {Executable: true}}
+	if err := n.PutFiles(files, meta); err != nil {
+		t.Fatalf("PutFiles: %v", err)
+	}
+	got, err := n.GetFiles([]string{"*.c", "tests/*"})
+	if err != nil {
+		t.Fatalf("GetFiles: %v", err)
+	}
+	if !reflect.DeepEqual(got, files) {
+		t.Errorf("GetFiles returned %q, want %q", got, files)
+	}
+
+	// files written by a command are seen afterward
+	if _, _, err := execWithin(t, n, []string{"make"}); err != nil {
+		t.Fatalf("Exec: %v", err)
+	}
+	got, err = n.GetFiles([]string{"out.txt"})
+	if err != nil {
+		t.Fatalf("GetFiles: %v", err)
+	}
+	if string(got["out.txt"]) != "output\n" {
+		t.Errorf("GetFiles after Exec returned %q, want out.txt", got)
+	}
+}
+
+func TestNannyExec(t *testing.T) {
+	tests := []struct {
+		name   string
+		run    func(killed chan struct{}) int
+		status int
+		err    string
+		events []string
+	}{
+		{
+			name:   "success",
+			run:    func(killed chan struct{}) int { return 0 },
+			status: 0,
+			events: []string{"exec", "exit"},
+		},
+		{
+			name:   "failure",
+			run:    func(killed chan struct{}) int { return 2 },
+			status: 2,
+			events: []string{"exec", "exit"},
+		},
+		{
+			name:   "cpu limit",
+			run:    func(killed chan struct{}) int { return 128 + int(syscall.SIGXCPU) },
+			status: 128 + int(syscall.SIGXCPU),
+			err:    "killed: exceeded 10 second CPU time limit",
+			events: []string{"exec", "exit", "error"},
+		},
+		{
+			name:   "file size limit",
+			run:    func(killed chan struct{}) int { return 128 + int(syscall.SIGXFSZ) },
+			status: 128 + int(syscall.SIGXFSZ),
+			err:    "killed: exceeded 5 MB file size limit",
+			events: []string{"exec", "exit", "error"},
+		},
+		{
+			name: "time limit",
+			run: func(killed chan struct{}) int {
+				<-killed
+				return 128 + int(syscall.SIGKILL)
+			},
+			status: 128 + int(syscall.SIGKILL),
+			err:    "killed: exceeded the time limit of 50ms",
+			events: []string{"exec", "exit", "error"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			engine := newFakeEngine()
+			killed := make(chan struct{})
+			engine.Run = func(files map[string][]byte, cmd []string, opts *ExecOptions) int {
+				if strings.Join(cmd, " ") == "/bin/sh -c kill -KILL -1" {
+					close(killed)
+					return 0
+				}
+				return test.run(killed)
+			}
+			n := newFakeNanny(t, engine, &ProblemTypeAction{Action: "grade", MaxCPU: 10, MaxFileSize: 5})
+			n.execTimeout = 50 * time.Millisecond
+
+			_, status, err := execWithin(t, n, []string{"make", "grade"})
+			if status != test.status {
+				t.Errorf("status is %d, want %d", status, test.status)
+			}
+			if test.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			} else if test.err != "" && (err == nil || err.Error() != test.err) {
+				t.Errorf("error is %v, want %s", err, test.err)
+			}
+			if names := n.eventNames(); !reflect.DeepEqual(names, test.events) {
+				t.Errorf("events are %q, want %q", names, test.events)
+			}
+		})
+	}
+}
+
+func TestExecInput(t *testing.T) {
+	// the command echoes its input until it is closed
+	engine := newFakeEngine()
+	engine.Run = func(files map[string][]byte, cmd []string, opts *ExecOptions) int {
+		input, err := ioutil.ReadAll(opts.Stdin)
+		if err != nil {
+			t.Errorf("reading stdin: %v", err)
+		}
+		opts.Stdout.Write(input)
+		return 0
+	}
+	n := newFakeNanny(t, engine, &ProblemTypeAction{Action: "run", Interactive: true})
+
+	go func() {
+		n.SendInput("hello\n")
+		n.SendInput("world\n")
+		n.CloseInput()
+	}()
+	stdout, status, err := execWithin(t, n, []string{"cat"})
+	if err != nil || status != 0 {
+		t.Errorf("Exec returned status %d, error %v", status, err)
+	}
+	if stdout != "hello\nworld\n" {
+		t.Errorf("stdout is %q, want the input echoed", stdout)
+	}
+	want := []string{"exec", "stdin", "stdin", "stdinclosed", "stdout", "exit"}
+	if names := n.eventNames(); !reflect.DeepEqual(names, want) {
+		t.Errorf("events are %q, want %q", names, want)
+	}
+}
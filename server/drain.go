@@ -0,0 +1,117 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// drainLogInterval is how often a draining daycare logs how many
+// sessions it is still waiting for.
+const drainLogInterval = 30 * time.Second
+
+// daycareDrain tracks running daycare sessions so the daycare can shut down
+// without cutting them off. Once draining starts, no new sessions are accepted.
+type daycareDrain struct {
+	sync.Mutex
+	draining bool
+	started  chan struct{}
+	sessions sync.WaitGroup
+	count    int
+}
+
+var drain = &daycareDrain{started: make(chan struct{})}
+
+// Enter records the start of a session. It returns false if the daycare
+// is draining and the session must be refused.
+func (d *daycareDrain) Enter() bool {
+	d.Lock()
+	defer d.Unlock()
+	if d.draining {
+		return false
+	}
+	d.sessions.Add(1)
+	d.count++
+	return true
+}
+
+// Leave records the end of a session.
+func (d *daycareDrain) Leave() {
+	d.Lock()
+	d.count--
+	d.Unlock()
+	d.sessions.Done()
+}
+
+// Draining returns a channel that is closed when draining starts.
+func (d *daycareDrain) Draining() <-chan struct{} {
+	return d.started
+}
+
+// Capacity is the capacity the daycare advertises to the TA: the
+// configured value, or zero once draining starts.
+func (d *daycareDrain) Capacity(configured int) int {
+	select {
+	case <-d.started:
+		return 0
+	default:
+		return configured
+	}
+}
+
+// finished returns a channel that is closed once every session has left.
+func (d *daycareDrain) finished() <-chan struct{} {
+	finished := make(chan struct{})
+	go func() {
+		d.sessions.Wait()
+		close(finished)
+	}()
+	return finished
+}
+
+func (d *daycareDrain) start() {
+	d.Lock()
+	defer d.Unlock()
+	if !d.draining {
+		d.draining = true
+		close(d.started)
+	}
+}
+
+func (d *daycareDrain) remaining() int {
+	d.Lock()
+	defer d.Unlock()
+	return d.count
+}
+
+// drainOnSignal waits for SIGTERM or SIGINT, then stops accepting new
+// daycare sessions, waits for the running ones to finish, and exits.
+// A second signal exits immediately.
+func drainOnSignal() {
+	signals := make(chan os.Signal, 2)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGINT)
+	sig := <-signals
+	log.Printf("received %v, draining daycare: refusing new sessions and waiting for %d running ones to finish", sig, drain.remaining())
+	drain.start()
+
+	finished := drain.finished()
+	ticker := time.NewTicker(drainLogInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-finished:
+			if warmPool != nil {
+				warmPool.Close()
+			}
+			log.Printf("daycare drained, exiting")
+			os.Exit(0)
+		case sig := <-signals:
+			log.Fatalf("received %v while draining, exiting with %d sessions still running", sig, drain.remaining())
+		case <-ticker.C:
+			log.Printf("still waiting for %d daycare sessions to finish", drain.remaining())
+		}
+	}
+}
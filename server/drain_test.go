@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDrain(t *testing.T) {
+	d := &daycareDrain{started: make(chan struct{})}
+	if capacity := d.Capacity(4); capacity != 4 {
+		t.Errorf("capacity %d before draining, want 4", capacity)
+	}
+	if !d.Enter() || !d.Enter() {
+		t.Fatalf("session refused before draining")
+	}
+
+	d.start()
+	if !isClosed(d.Draining()) {
+		t.Errorf("Draining not closed after draining started")
+	}
+	if capacity := d.Capacity(4); capacity != 0 {
+		t.Errorf("capacity %d while draining, want 0", capacity)
+	}
+	if d.Enter() {
+		t.Errorf("new session accepted while draining")
+	}
+
+	// draining waits for every running session
+	finished := d.finished()
+	d.Leave()
+	time.Sleep(10 * time.Millisecond)
+	if isClosed(finished) {
+		t.Errorf("drain finished with a session still running")
+	}
+	if remaining := d.remaining(); remaining != 1 {
+		t.Errorf("%d sessions remaining, want 1", remaining)
+	}
+	d.Leave()
+	if !closedWithin(finished) {
+		t.Errorf("drain did not finish after the last session left")
+	}
+}
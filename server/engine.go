@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// ContainerEngine is the interface the nanny uses to manage containers.
+// Containers are identified by the ID returned from Create.
+type ContainerEngine interface {
+	// Create starts a new container in the background and returns its ID.
+	// It returns errContainerExists if the name is already taken.
+	Create(spec *ContainerSpec) (string, error)
+
+	// PutFiles extracts a tar archive into a directory in a running container.
+	PutFiles(id, dir string, archive io.Reader) error
+
+	// Exec runs a command in a running container, wiring up the streams
+	// given in the options, and returns its exit status.
+	// An error is only returned if the command could not be run.
+	Exec(id string, cmd []string, opts *ExecOptions) (int, error)
+
+	// GetFiles returns the contents of a directory in a container as a tar archive.
+	GetFiles(id, dir string) (io.ReadCloser, error)
+
+	// Rename gives a container a new name.
+	// It returns errContainerExists if the name is already taken.
+	Rename(id, name string) error
+
+	// State reports whether a container is running and whether the kernel
+	// OOM killer has fired inside it.
+	State(id string) (*ContainerState, error)
+
+	// List finds all containers, running or not, with the given label value.
+	List(label, value string) ([]*ContainerInfo, error)
+
+	// Kill stops a container without removing it.
+	Kill(id string) error
+
+	// Remove forcefully stops and removes a container.
+	Remove(id string) error
+
+	// CreateNetwork creates an internal network with no route off the host.
+	// Creating a network that already exists is not an error.
+	CreateNetwork(name string, labels map[string]string) error
+
+	// RemoveNetwork removes a network. Removing a missing network is not an error.
+	RemoveNetwork(name string) error
+
+	// PruneNetworks removes networks with the given label value that no
+	// container is using and that are older than the given age.
+	PruneNetworks(label, value string, olderThan time.Duration) error
+
+	// ImageDigest returns the ID of a local image named by tag or by ID,
+	// such as "sha256:...", or an empty string if the image is not present. This is the image ID
+	// and not a registry digest, so it names exactly the image that runs.
+	ImageDigest(image string) (string, error)
+}
+
+// ContainerSpec describes a container to be created.
+// Resource limits of zero are not enforced.
+type ContainerSpec struct {
+	Name        string
+	Image       string
+	Command     []string
+	UID         int   // zero means the image's default user
+	MaxCPU      int64 // seconds of CPU time
+	MaxFD       int64 // open files
+	MaxFileSize int64 // megabytes
+	MaxMemory   int64 // megabytes
+	MaxThreads  int64
+	Labels      map[string]string
+
+	// Network is the network to join; empty means no network at all.
+	// Other containers on the network can reach this one by its aliases.
+	Network        string
+	NetworkAliases []string
+
+	// Runtime and SeccompProfile replace the engine's defaults when set.
+	// Tmpfs maps mount points to mount options.
+	Runtime        string
+	SeccompProfile []byte
+	ReadOnlyRootfs bool
+	Tmpfs          map[string]string
+
+	// Volumes are named volumes to mount, created by the engine on first use.
+	Volumes []VolumeMount
+}
+
+// VolumeMount mounts a named volume into a container.
+type VolumeMount struct {
+	Volume   string
+	Target   string
+	ReadOnly bool
+}
+
+// ExecOptions gives the user and streams for a command run in a container.
+// A nil Stdin means the command gets no input. With TTY set the command runs
+// on a terminal, all output goes to Stdout, and new window sizes are read
+// from Resize until the command exits.
+type ExecOptions struct {
+	UID    int
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+	TTY    bool
+	Resize <-chan TerminalSize
+}
+
+// ContainerState is the current state of a container.
+type ContainerState struct {
+	Running   bool
+	OOMKilled bool
+	ExitCode  int
+	Pid       int // main process on the host, or 0 if not running
+}
+
+// ContainerInfo describes an existing container.
+type ContainerInfo struct {
+	ID      string
+	Name    string
+	Created time.Time
+}
+
+var errContainerExists = errors.New("container name is already in use")
+
+// containerEngine is the engine used by new nannies.
+var containerEngine ContainerEngine
+
+// newContainerEngine returns the container engine with the given name from the config file.
+// socket is the path of the Docker Engine API socket, used by the "docker" engine.
+func newContainerEngine(name, socket string) (ContainerEngine, error) {
+	switch name {
+	case "", "docker":
+		return newDockerEngine(socket), nil
+	case "docker-cli":
+		return &cliEngine{command: "docker"}, nil
+	case "podman":
+		return &cliEngine{command: "podman"}, nil
+	case "fake":
+		return newFakeEngine(), nil
+	default:
+		return nil, fmt.Errorf("unknown container engine %q", name)
+	}
+}
+
+// putFilesWithTar extracts a tar archive into a directory by running tar
+// inside the container. The engines copy files through the container's
+// root filesystem, which fails when it is read-only, even if the target
+// directory is a writable tmpfs mount.
+func putFilesWithTar(engine ContainerEngine, id, dir string, uid int, archive io.Reader) error {
+	var output bytes.Buffer
+	opts := &ExecOptions{UID: uid, Stdin: archive, Stdout: &output, Stderr: &output}
+	status, err := engine.Exec(id, []string{"tar", "-x", "-f", "-", "-C", dir}, opts)
+	if err != nil {
+		return err
+	}
+	if status != 0 {
+		return fmt.Errorf("tar exited with status %d: %s", status, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// getFilesWithTar is like GetFiles, but runs tar inside the container
+// for the same reason as putFilesWithTar.
+func getFilesWithTar(engine ContainerEngine, id, dir string, uid int) (io.ReadCloser, error) {
+	var archive, stderr bytes.Buffer
+	opts := &ExecOptions{UID: uid, Stdout: &archive, Stderr: &stderr}
+	status, err := engine.Exec(id, []string{"tar", "-c", "-f", "-", "-C", dir, "."}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if status != 0 {
+		return nil, fmt.Errorf("tar exited with status %d: %s", status, strings.TrimSpace(stderr.String()))
+	}
+	return ioutil.NopCloser(&archive), nil
+}
+
+// cliEngine manages containers by running the docker command-line tool
+// or a compatible replacement such as podman.
+type cliEngine struct {
+	command string
+}
+
+func (e *cliEngine) Create(spec *ContainerSpec) (string, error) {
+	memStr := fmt.Sprintf("%dm", spec.MaxMemory)
+	network := "none"
+	if spec.Network != "" {
+		network = spec.Network
+	}
+
+	// construct the 'run' command arguments
+	cmdArgs := []string{
+		"run",
+		"-d", // detached mode.
+		"--name", spec.Name,
+		"--hostname", spec.Name,
+		"--net=" + network,
+
+		// cgroup-based resource limits.
+		"--memory", memStr,
+		"--memory-swap", memStr, // prevent swapping
+		"--pids-limit", strconv.FormatInt(spec.MaxThreads, 10),
+
+		// security hardening flags.
+		"--cap-drop", "ALL",
+		"--security-opt", "no-new-privileges", // prevent privilege escalation
+
+		// ulimits for resources not covered by cgroups.
+		// note: --pids-limit makes nproc redundant
+		"--ulimit", fmt.Sprintf("core=0:0"),
+	}
+	if spec.UID != 0 {
+		cmdArgs = append(cmdArgs, "--user", fmt.Sprintf("%d:%d", spec.UID, spec.UID))
+	}
+	for _, alias := range spec.NetworkAliases {
+		cmdArgs = append(cmdArgs, "--network-alias", alias)
+	}
+	if spec.MaxCPU > 0 {
+		cmdArgs = append(cmdArgs, "--ulimit", fmt.Sprintf("cpu=%d", spec.MaxCPU))
+	}
+	if spec.MaxFileSize > 0 {
+		cmdArgs = append(cmdArgs, "--ulimit", fmt.Sprintf("fsize=%d", spec.MaxFileSize*1024*1024))
+	}
+	if spec.MaxFD > 0 {
+		cmdArgs = append(cmdArgs, "--ulimit", fmt.Sprintf("nofile=%d:%d", spec.MaxFD, spec.MaxFD))
+	}
+
+	// sandbox hardening
+	if spec.Runtime != "" {
+		cmdArgs = append(cmdArgs, "--runtime", spec.Runtime)
+	}
+	if spec.SeccompProfile != nil {
+		// the command-line tools read the profile from a file
+		profile, err := ioutil.TempFile("", "seccomp-*.json")
+		if err != nil {
+			return "", fmt.Errorf("writing seccomp profile: %v", err)
+		}
+		defer os.Remove(profile.Name())
+		_, err = profile.Write(spec.SeccompProfile)
+		if closeErr := profile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return "", fmt.Errorf("writing seccomp profile: %v", err)
+		}
+		cmdArgs = append(cmdArgs, "--security-opt", "seccomp="+profile.Name())
+	}
+	if spec.ReadOnlyRootfs {
+		cmdArgs = append(cmdArgs, "--read-only")
+	}
+	mounts := make([]string, 0, len(spec.Tmpfs))
+	for path := range spec.Tmpfs {
+		mounts = append(mounts, path)
+	}
+	sort.Strings(mounts)
+	for _, path := range mounts {
+		cmdArgs = append(cmdArgs, "--tmpfs", path+":"+spec.Tmpfs[path])
+	}
+	for _, volume := range spec.Volumes {
+		mount := fmt.Sprintf("type=volume,source=%s,target=%s", volume.Volume, volume.Target)
+		if volume.ReadOnly {
+			mount += ",readonly"
+		}
+		cmdArgs = append(cmdArgs, "--mount", mount)
+	}
+
+	for _, label := range sortedLabels(spec.Labels) {
+		cmdArgs = append(cmdArgs, "--label", label)
+	}
+	cmdArgs = append(cmdArgs, spec.Image)
+	cmdArgs = append(cmdArgs, spec.Command...)
+
+	output, err := exec.Command(e.command, cmdArgs...).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "is already in use") {
+			return "", errContainerExists
+		}
+		return "", fmt.Errorf("%s run failed: %v\nOutput: %s", e.command, err, string(output))
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (e *cliEngine) PutFiles(id, dir string, archive io.Reader) error {
+	// note: the container must be running
+	cmd := exec.Command(e.command, "cp", "-", id+":"+dir)
+	cmd.Stdin = archive
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s cp failed: %v\nOutput: %s", e.command, err, string(output))
+	}
+	return nil
+}
+
+func (e *cliEngine) Exec(id string, cmd []string, opts *ExecOptions) (int, error) {
+	if opts.TTY {
+		// the command-line tools only allocate a terminal when their own stdin is one
+		return -1, fmt.Errorf("exec command failed: the %s engine does not support terminal sessions, use the docker engine", e.command)
+	}
+	execCmdArgs := []string{"exec", "--user", strconv.Itoa(opts.UID)}
+	if opts.Stdin != nil {
+		// keep stdin open so input can be streamed to the command
+		execCmdArgs = append(execCmdArgs, "--interactive")
+	}
+	execCmdArgs = append(execCmdArgs, id)
+	execCmdArgs = append(execCmdArgs, cmd...)
+	command := exec.Command(e.command, execCmdArgs...)
+	command.Stdout = opts.Stdout
+	command.Stderr = opts.Stderr
+
+	// copy stdin ourselves: if exec.Cmd did it, Wait would block
+	// until the input stream closed even after the command had exited
+	if opts.Stdin != nil {
+		stdin, err := command.StdinPipe()
+		if err != nil {
+			return -1, fmt.Errorf("exec command stdin pipe: %v", err)
+		}
+		go func() {
+			io.Copy(stdin, opts.Stdin)
+			stdin.Close()
+		}()
+	}
+
+	if err := command.Run(); err != nil {
+		// try to extract the exit code from the error
+		if exitError, ok := err.(*exec.ExitError); ok {
+			return exitError.ExitCode(), nil
+		}
+
+		// a different error occurred (e.g., command not found).
+		return -1, fmt.Errorf("exec command failed: %v", err)
+	}
+	return 0, nil
+}
+
+func (e *cliEngine) GetFiles(id, dir string) (io.ReadCloser, error) {
+	cmd := exec.Command(e.command, "cp", id+":"+dir, "-")
+	var tarFile, stderr bytes.Buffer
+	cmd.Stdout = &tarFile
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("%s cp from container failed: %v\nOutput: %s", e.command, err, stderr.String())
+	}
+	return ioutil.NopCloser(&tarFile), nil
+}
+
+func (e *cliEngine) Rename(id, name string) error {
+	output, err := exec.Command(e.command, "rename", id, name).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "is already in use") {
+			return errContainerExists
+		}
+		return fmt.Errorf("error renaming container %s: %v\nOutput: %s", id, err, string(output))
+	}
+	return nil
+}
+
+func (e *cliEngine) State(id string) (*ContainerState, error) {
+	output, err := exec.Command(e.command, "inspect", "--format", "{{json .State}}", id).CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting container %s: %v\nOutput: %s", id, err, string(output))
+	}
+	var state ContainerState
+	if err := json.Unmarshal(output, &state); err != nil {
+		return nil, fmt.Errorf("error decoding state of container %s: %v", id, err)
+	}
+	return &state, nil
+}
+
+func (e *cliEngine) ImageDigest(image string) (string, error) {
+	output, err := exec.Command(e.command, "image", "inspect", "--format", "{{.Id}}", image).CombinedOutput()
+	if err != nil {
+		msg := strings.ToLower(string(output))
+		if strings.Contains(msg, "no such image") || strings.Contains(msg, "image not known") {
+			return "", nil
+		}
+		return "", fmt.Errorf("error inspecting image %s: %v\nOutput: %s", image, err, string(output))
+	}
+
+	// podman leaves off the algorithm
+	digest := strings.TrimSpace(string(output))
+	if !strings.Contains(digest, ":") {
+		digest = "sha256:" + digest
+	}
+	return digest, nil
+}
+
+func (e *cliEngine) List(label, value string) ([]*ContainerInfo, error) {
+	output, err := exec.Command(e.command, "ps", "--all", "--quiet", "--no-trunc", "--filter", "label="+label+"="+value).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %v", err)
+	}
+	ids := strings.Fields(string(output))
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	// inspect gives the same fields for docker and podman
+	output, err = exec.Command(e.command, append([]string{"inspect"}, ids...)...).Output()
+	if err != nil {
+		return nil, fmt.Errorf("error inspecting containers: %v", err)
+	}
+	var details []struct {
+		ID      string `json:"Id"`
+		Name    string
+		Created time.Time
+	}
+	if err := json.Unmarshal(output, &details); err != nil {
+		return nil, fmt.Errorf("error decoding container list: %v", err)
+	}
+	var list []*ContainerInfo
+	for _, elt := range details {
+		list = append(list, &ContainerInfo{
+			ID:      elt.ID,
+			Name:    strings.TrimPrefix(elt.Name, "/"),
+			Created: elt.Created,
+		})
+	}
+	return list, nil
+}
+
+func (e *cliEngine) Kill(id string) error {
+	if output, err := exec.Command(e.command, "kill", id).CombinedOutput(); err != nil {
+		return fmt.Errorf("error killing container %s: %v\nOutput: %s", id, err, string(output))
+	}
+	return nil
+}
+
+func (e *cliEngine) Remove(id string) error {
+	if err := exec.Command(e.command, "rm", "-f", id).Run(); err != nil {
+		return fmt.Errorf("error removing container %s: %v", id, err)
+	}
+	return nil
+}
+
+func (e *cliEngine) CreateNetwork(name string, labels map[string]string) error {
+	args := []string{"network", "create", "--internal"}
+	for _, label := range sortedLabels(labels) {
+		args = append(args, "--label", label)
+	}
+	args = append(args, name)
+	output, err := exec.Command(e.command, args...).CombinedOutput()
+	if err != nil {
+		if strings.Contains(string(output), "already exists") {
+			return nil
+		}
+		return fmt.Errorf("%s network create failed: %v\nOutput: %s", e.command, err, string(output))
+	}
+	return nil
+}
+
+func (e *cliEngine) RemoveNetwork(name string) error {
+	output, err := exec.Command(e.command, "network", "rm", name).CombinedOutput()
+	if err != nil {
+		if strings.Contains(strings.ToLower(string(output)), "not found") {
+			return nil
+		}
+		return fmt.Errorf("error removing network %s: %v\nOutput: %s", name, err, string(output))
+	}
+	return nil
+}
+
+func (e *cliEngine) PruneNetworks(label, value string, olderThan time.Duration) error {
+	args := []string{"network", "prune", "--force", "--filter", "label=" + label + "=" + value}
+	if olderThan > 0 {
+		args = append(args, "--filter", "until="+olderThan.String())
+	}
+	if output, err := exec.Command(e.command, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s network prune failed: %v\nOutput: %s", e.command, err, string(output))
+	}
+	return nil
+}
+
+// sortedLabels formats labels as key=value in a stable order.
+func sortedLabels(labels map[string]string) []string {
+	list := make([]string, 0, len(labels))
+	for key, value := range labels {
+		list = append(list, key+"="+value)
+	}
+	sort.Strings(list)
+	return list
+}
@@ -0,0 +1,559 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// dockerAPIVersion is the Engine API version requested on every call.
+// 1.41 is supported by Docker 20.10 and later.
+const dockerAPIVersion = "v1.41"
+
+const defaultDockerSocket = "/var/run/docker.sock"
+
+// dockerEngine talks to the Docker Engine API over its unix socket,
+// avoiding a fork of the docker CLI for every container operation.
+type dockerEngine struct {
+	socket string
+	client *http.Client
+}
+
+func newDockerEngine(socket string) *dockerEngine {
+	if socket == "" {
+		socket = defaultDockerSocket
+	}
+	e := &dockerEngine{socket: socket}
+	e.client = &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", e.socket)
+			},
+		},
+	}
+	return e
+}
+
+// dockerError is the body of an error response from the Engine API.
+type dockerError struct {
+	Message string `json:"message"`
+}
+
+func (e *dockerEngine) url(path string, query url.Values) string {
+	u := "http://docker/" + dockerAPIVersion + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	return u
+}
+
+// call makes a request to the Engine API and decodes a JSON response into out
+// (if out is not nil). Non-2xx responses are returned as errors along with
+// their status code so callers can react to specific failures.
+func (e *dockerEngine) call(method, path string, query url.Values, body io.Reader, contentType string, out interface{}) (int, error) {
+	req, err := http.NewRequest(method, e.url(path, query), body)
+	if err != nil {
+		return 0, fmt.Errorf("docker %s %s: %v", method, path, err)
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("docker %s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var msg dockerError
+		raw, _ := ioutil.ReadAll(resp.Body)
+		if err := json.Unmarshal(raw, &msg); err != nil || msg.Message == "" {
+			msg.Message = strings.TrimSpace(string(raw))
+		}
+		return resp.StatusCode, fmt.Errorf("docker %s %s: %s: %s", method, path, resp.Status, msg.Message)
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("docker %s %s: decoding response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode, nil
+}
+
+// callJSON is like call, but encodes in as a JSON request body.
+func (e *dockerEngine) callJSON(method, path string, query url.Values, in, out interface{}) (int, error) {
+	raw, err := json.Marshal(in)
+	if err != nil {
+		return 0, fmt.Errorf("docker %s %s: encoding request: %v", method, path, err)
+	}
+	return e.call(method, path, query, bytes.NewReader(raw), "application/json", out)
+}
+
+type dockerUlimit struct {
+	Name string
+	Soft int64
+	Hard int64
+}
+
+type dockerHostConfig struct {
+	NetworkMode    string
+	Memory         int64
+	MemorySwap     int64
+	PidsLimit      int64
+	CapDrop        []string
+	SecurityOpt    []string
+	Ulimits        []dockerUlimit
+	Runtime        string            `json:",omitempty"`
+	ReadonlyRootfs bool              `json:",omitempty"`
+	Tmpfs          map[string]string `json:",omitempty"`
+	Mounts         []dockerMount     `json:",omitempty"`
+}
+
+type dockerMount struct {
+	Type     string
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+type dockerEndpointConfig struct {
+	Aliases []string `json:",omitempty"`
+}
+
+type dockerNetworkingConfig struct {
+	EndpointsConfig map[string]*dockerEndpointConfig `json:",omitempty"`
+}
+
+type dockerCreateRequest struct {
+	Hostname         string
+	User             string `json:",omitempty"`
+	Image            string
+	Cmd              []string `json:",omitempty"`
+	Labels           map[string]string
+	NetworkDisabled  bool
+	HostConfig       dockerHostConfig
+	NetworkingConfig dockerNetworkingConfig
+}
+
+type dockerNetworkCreateRequest struct {
+	Name           string
+	CheckDuplicate bool
+	Internal       bool
+	Labels         map[string]string
+}
+
+type dockerCreateResponse struct {
+	ID       string `json:"Id"`
+	Warnings []string
+}
+
+func (e *dockerEngine) Create(spec *ContainerSpec) (string, error) {
+	mem := spec.MaxMemory * 1024 * 1024
+	req := &dockerCreateRequest{
+		Hostname:        spec.Name,
+		Image:           spec.Image,
+		Cmd:             spec.Command,
+		Labels:          spec.Labels,
+		NetworkDisabled: spec.Network == "",
+		HostConfig: dockerHostConfig{
+			NetworkMode: "none",
+
+			// cgroup-based resource limits.
+			Memory:     mem,
+			MemorySwap: mem, // prevent swapping
+			PidsLimit:  spec.MaxThreads,
+
+			// security hardening.
+			CapDrop:     []string{"ALL"},
+			SecurityOpt: []string{"no-new-privileges"},
+
+			// ulimits for resources not covered by cgroups.
+			Ulimits: []dockerUlimit{
+				{Name: "core", Soft: 0, Hard: 0},
+			},
+		},
+	}
+	if spec.UID != 0 {
+		req.User = fmt.Sprintf("%d:%d", spec.UID, spec.UID)
+	}
+	if spec.Network != "" {
+		req.HostConfig.NetworkMode = spec.Network
+		req.NetworkingConfig.EndpointsConfig = map[string]*dockerEndpointConfig{
+			spec.Network: {Aliases: spec.NetworkAliases},
+		}
+	}
+	if spec.MaxCPU > 0 {
+		req.HostConfig.Ulimits = append(req.HostConfig.Ulimits, dockerUlimit{Name: "cpu", Soft: spec.MaxCPU, Hard: spec.MaxCPU})
+	}
+	if spec.MaxFileSize > 0 {
+		req.HostConfig.Ulimits = append(req.HostConfig.Ulimits, dockerUlimit{Name: "fsize", Soft: spec.MaxFileSize * 1024 * 1024, Hard: spec.MaxFileSize * 1024 * 1024})
+	}
+	if spec.MaxFD > 0 {
+		req.HostConfig.Ulimits = append(req.HostConfig.Ulimits, dockerUlimit{Name: "nofile", Soft: spec.MaxFD, Hard: spec.MaxFD})
+	}
+
+	// sandbox hardening; the API takes the seccomp profile itself, not a path
+	req.HostConfig.Runtime = spec.Runtime
+	if spec.SeccompProfile != nil {
+		req.HostConfig.SecurityOpt = append(req.HostConfig.SecurityOpt, "seccomp="+string(spec.SeccompProfile))
+	}
+	req.HostConfig.ReadonlyRootfs = spec.ReadOnlyRootfs
+	req.HostConfig.Tmpfs = spec.Tmpfs
+	for _, volume := range spec.Volumes {
+		req.HostConfig.Mounts = append(req.HostConfig.Mounts, dockerMount{
+			Type:     "volume",
+			Source:   volume.Volume,
+			Target:   volume.Target,
+			ReadOnly: volume.ReadOnly,
+		})
+	}
+
+	var resp dockerCreateResponse
+	status, err := e.callJSON("POST", "/containers/create", url.Values{"name": {spec.Name}}, req, &resp)
+	if status == http.StatusConflict {
+		return "", errContainerExists
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := e.call("POST", "/containers/"+resp.ID+"/start", nil, nil, "", nil); err != nil {
+		e.Remove(resp.ID)
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+func (e *dockerEngine) PutFiles(id, dir string, archive io.Reader) error {
+	_, err := e.call("PUT", "/containers/"+id+"/archive", url.Values{"path": {dir}}, archive, "application/x-tar", nil)
+	return err
+}
+
+func (e *dockerEngine) GetFiles(id, dir string) (io.ReadCloser, error) {
+	// the archive is buffered so the connection is released right away
+	req, err := http.NewRequest("GET", e.url("/containers/"+id+"/archive", url.Values{"path": {dir}}), nil)
+	if err != nil {
+		return nil, fmt.Errorf("docker GET archive: %v", err)
+	}
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("docker GET archive: %v", err)
+	}
+	defer resp.Body.Close()
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("docker GET archive: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		var msg dockerError
+		if err := json.Unmarshal(raw, &msg); err != nil || msg.Message == "" {
+			msg.Message = strings.TrimSpace(string(raw))
+		}
+		return nil, fmt.Errorf("docker GET archive: %s: %s", resp.Status, msg.Message)
+	}
+	return ioutil.NopCloser(bytes.NewReader(raw)), nil
+}
+
+type dockerExecCreateRequest struct {
+	User         string
+	Cmd          []string
+	AttachStdin  bool
+	AttachStdout bool
+	AttachStderr bool
+	Tty          bool
+}
+
+type dockerExecInspectResponse struct {
+	Running  bool
+	ExitCode int
+}
+
+func (e *dockerEngine) Exec(id string, cmd []string, opts *ExecOptions) (int, error) {
+	// create the exec instance
+	create := &dockerExecCreateRequest{
+		User:         fmt.Sprintf("%d", opts.UID),
+		Cmd:          cmd,
+		AttachStdin:  opts.Stdin != nil,
+		AttachStdout: true,
+		AttachStderr: !opts.TTY,
+		Tty:          opts.TTY,
+	}
+	var created dockerCreateResponse
+	if _, err := e.callJSON("POST", "/containers/"+id+"/exec", nil, create, &created); err != nil {
+		return -1, fmt.Errorf("exec command failed: %v", err)
+	}
+
+	// start it and take over the connection for the attached streams
+	conn, err := net.Dial("unix", e.socket)
+	if err != nil {
+		return -1, fmt.Errorf("exec command failed: %v", err)
+	}
+	defer conn.Close()
+	body, err := json.Marshal(map[string]bool{"Detach": false, "Tty": opts.TTY})
+	if err != nil {
+		return -1, fmt.Errorf("exec command failed: %v", err)
+	}
+	req, err := http.NewRequest("POST", e.url("/exec/"+created.ID+"/start", nil), bytes.NewReader(body))
+	if err != nil {
+		return -1, fmt.Errorf("exec command failed: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "tcp")
+	if err := req.Write(conn); err != nil {
+		return -1, fmt.Errorf("exec command failed: %v", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return -1, fmt.Errorf("exec command failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols && resp.StatusCode != http.StatusOK {
+		raw, _ := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		return -1, fmt.Errorf("exec command failed: %s: %s", resp.Status, strings.TrimSpace(string(raw)))
+	}
+
+	// copy stdin in the background; closing our write side signals EOF
+	if opts.Stdin != nil {
+		go func() {
+			io.Copy(conn, opts.Stdin)
+			if uc, ok := conn.(*net.UnixConn); ok {
+				uc.CloseWrite()
+			}
+		}()
+	}
+
+	// a terminal produces a single raw stream; otherwise
+	// demultiplex stdout and stderr until the command exits
+	if opts.TTY {
+		finished := make(chan struct{})
+		defer close(finished)
+		if opts.Resize != nil {
+			go e.resizeExec(created.ID, opts.Resize, finished)
+		}
+		if _, err := io.Copy(opts.Stdout, br); err != nil && !isClosedConnError(err) {
+			return -1, fmt.Errorf("exec command failed: reading output: %v", err)
+		}
+	} else if err := demuxDockerStream(br, opts.Stdout, opts.Stderr); err != nil {
+		return -1, fmt.Errorf("exec command failed: reading output: %v", err)
+	}
+
+	// collect the exit status; it can lag slightly behind the end of the stream
+	for attempt := 0; ; attempt++ {
+		var info dockerExecInspectResponse
+		if _, err := e.call("GET", "/exec/"+created.ID+"/json", nil, nil, "", &info); err != nil {
+			return -1, fmt.Errorf("exec command failed: %v", err)
+		}
+		if !info.Running {
+			return info.ExitCode, nil
+		}
+		if attempt >= 50 {
+			return -1, fmt.Errorf("exec command failed: command still running after its output closed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// resizeExec passes terminal size changes on to a running exec instance.
+func (e *dockerEngine) resizeExec(execID string, sizes <-chan TerminalSize, finished <-chan struct{}) {
+	for {
+		select {
+		case <-finished:
+			return
+		case size := <-sizes:
+			query := url.Values{
+				"h": {strconv.Itoa(size.Rows)},
+				"w": {strconv.Itoa(size.Cols)},
+			}
+			if _, err := e.call("POST", "/exec/"+execID+"/resize", query, nil, "", nil); err != nil {
+				log.Printf("error resizing terminal: %v", err)
+			}
+		}
+	}
+}
+
+// isClosedConnError reports whether a read failed because the container
+// side hung up, which a terminal stream does instead of a clean EOF.
+func isClosedConnError(err error) bool {
+	return errors.Is(err, net.ErrClosed) || errors.Is(err, syscall.ECONNRESET)
+}
+
+// demuxDockerStream splits an attached (non-TTY) stream into stdout and stderr.
+// Each frame has an 8-byte header: the stream number, three zero bytes,
+// and a big-endian 32-bit payload length.
+func demuxDockerStream(r io.Reader, stdout, stderr io.Writer) error {
+	var header [8]byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := int64(binary.BigEndian.Uint32(header[4:]))
+		var dst io.Writer
+		switch header[0] {
+		case 1:
+			dst = stdout
+		case 2:
+			dst = stderr
+		default:
+			dst = ioutil.Discard
+		}
+		if _, err := io.CopyN(dst, r, size); err != nil {
+			return err
+		}
+	}
+}
+
+func (e *dockerEngine) Rename(id, name string) error {
+	status, err := e.call("POST", "/containers/"+id+"/rename", url.Values{"name": {name}}, nil, "", nil)
+	if status == http.StatusConflict {
+		return errContainerExists
+	}
+	if err != nil {
+		return fmt.Errorf("error renaming container %s: %v", id, err)
+	}
+	return nil
+}
+
+type dockerInspectResponse struct {
+	State struct {
+		Running   bool
+		OOMKilled bool
+		ExitCode  int
+		Pid       int
+	}
+}
+
+func (e *dockerEngine) State(id string) (*ContainerState, error) {
+	var info dockerInspectResponse
+	if _, err := e.call("GET", "/containers/"+id+"/json", nil, nil, "", &info); err != nil {
+		return nil, err
+	}
+	return &ContainerState{
+		Running:   info.State.Running,
+		OOMKilled: info.State.OOMKilled,
+		ExitCode:  info.State.ExitCode,
+		Pid:       info.State.Pid,
+	}, nil
+}
+
+func (e *dockerEngine) ImageDigest(image string) (string, error) {
+	var info struct {
+		ID string `json:"Id"`
+	}
+	status, err := e.call("GET", "/images/"+image+"/json", nil, nil, "", &info)
+	if status == http.StatusNotFound {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("error inspecting image %s: %v", image, err)
+	}
+	return info.ID, nil
+}
+
+type dockerListEntry struct {
+	ID      string `json:"Id"`
+	Names   []string
+	Created int64
+}
+
+func (e *dockerEngine) List(label, value string) ([]*ContainerInfo, error) {
+	filters, err := json.Marshal(map[string][]string{"label": {label + "=" + value}})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %v", err)
+	}
+	var entries []dockerListEntry
+	query := url.Values{"all": {"true"}, "filters": {string(filters)}}
+	if _, err := e.call("GET", "/containers/json", query, nil, "", &entries); err != nil {
+		return nil, fmt.Errorf("error listing containers: %v", err)
+	}
+	var list []*ContainerInfo
+	for _, elt := range entries {
+		info := &ContainerInfo{ID: elt.ID, Created: time.Unix(elt.Created, 0)}
+		if len(elt.Names) > 0 {
+			info.Name = strings.TrimPrefix(elt.Names[0], "/")
+		}
+		list = append(list, info)
+	}
+	return list, nil
+}
+
+func (e *dockerEngine) Kill(id string) error {
+	if _, err := e.call("POST", "/containers/"+id+"/kill", nil, nil, "", nil); err != nil {
+		return fmt.Errorf("error killing container %s: %v", id, err)
+	}
+	return nil
+}
+
+func (e *dockerEngine) Remove(id string) error {
+	status, err := e.call("DELETE", "/containers/"+id, url.Values{"force": {"true"}}, nil, "", nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error removing container %s: %v", id, err)
+	}
+	return nil
+}
+
+func (e *dockerEngine) CreateNetwork(name string, labels map[string]string) error {
+	req := &dockerNetworkCreateRequest{
+		Name:           name,
+		CheckDuplicate: true,
+		Internal:       true,
+		Labels:         labels,
+	}
+	status, err := e.callJSON("POST", "/networks/create", nil, req, nil)
+	if status == http.StatusConflict {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error creating network %s: %v", name, err)
+	}
+	return nil
+}
+
+func (e *dockerEngine) RemoveNetwork(name string) error {
+	status, err := e.call("DELETE", "/networks/"+name, nil, nil, "", nil)
+	if status == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("error removing network %s: %v", name, err)
+	}
+	return nil
+}
+
+func (e *dockerEngine) PruneNetworks(label, value string, olderThan time.Duration) error {
+	filter := map[string][]string{"label": {label + "=" + value}}
+	if olderThan > 0 {
+		filter["until"] = []string{olderThan.String()}
+	}
+	filters, err := json.Marshal(filter)
+	if err != nil {
+		return fmt.Errorf("error pruning networks: %v", err)
+	}
+	if _, err := e.call("POST", "/networks/prune", url.Values{"filters": {string(filters)}}, nil, "", nil); err != nil {
+		return fmt.Errorf("error pruning networks: %v", err)
+	}
+	return nil
+}
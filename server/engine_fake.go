@@ -0,0 +1,278 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// fakeEngine is an in-process container engine for testing the daycare
+// without a container runtime. Files live in memory and commands are
+// handed to the Run function instead of being executed.
+type fakeEngine struct {
+	sync.Mutex
+	containers map[string]*fakeContainer
+	networks   map[string]*fakeNetwork
+	nextID     int
+
+	// Run handles each Exec call. It may read and modify the container's
+	// files, which are keyed by absolute path. The default echoes the command
+	// to stdout and exits with status 0.
+	Run func(files map[string][]byte, cmd []string, opts *ExecOptions) int
+}
+
+type fakeContainer struct {
+	spec    ContainerSpec
+	files   map[string][]byte
+	running bool
+	created time.Time
+}
+
+type fakeNetwork struct {
+	labels  map[string]string
+	created time.Time
+}
+
+func newFakeEngine() *fakeEngine {
+	return &fakeEngine{
+		containers: make(map[string]*fakeContainer),
+		networks:   make(map[string]*fakeNetwork),
+		Run: func(files map[string][]byte, cmd []string, opts *ExecOptions) int {
+			fmt.Fprintf(opts.Stdout, "%s\n", strings.Join(cmd, " "))
+			return 0
+		},
+	}
+}
+
+func (e *fakeEngine) container(id string) (*fakeContainer, error) {
+	c := e.containers[id]
+	if c == nil {
+		return nil, fmt.Errorf("no such container: %s", id)
+	}
+	return c, nil
+}
+
+func (e *fakeEngine) Create(spec *ContainerSpec) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	for _, c := range e.containers {
+		if c.spec.Name == spec.Name {
+			return "", errContainerExists
+		}
+	}
+	e.nextID++
+	id := fmt.Sprintf("fake%012d", e.nextID)
+	e.containers[id] = &fakeContainer{
+		spec:    *spec,
+		files:   make(map[string][]byte),
+		running: true,
+		created: time.Now(),
+	}
+	return id, nil
+}
+
+func (e *fakeEngine) PutFiles(id, dir string, archive io.Reader) error {
+	e.Lock()
+	defer e.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return err
+	}
+	if !c.running {
+		return fmt.Errorf("container %s is not running", id)
+	}
+	reader := tar.NewReader(archive)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("error decoding tar file: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		contents, err := ioutil.ReadAll(reader)
+		if err != nil {
+			return fmt.Errorf("error reading %q from tar file: %v", header.Name, err)
+		}
+		c.files[path.Join(dir, header.Name)] = contents
+	}
+	return nil
+}
+
+func (e *fakeEngine) Exec(id string, cmd []string, opts *ExecOptions) (int, error) {
+	e.Lock()
+	c, err := e.container(id)
+	if err == nil && !c.running {
+		err = fmt.Errorf("container %s is not running", id)
+	}
+	e.Unlock()
+	if err != nil {
+		return -1, fmt.Errorf("exec command failed: %v", err)
+	}
+
+	// note: Run is called without the lock so it can block on input
+	return e.Run(c.files, cmd, opts), nil
+}
+
+func (e *fakeEngine) GetFiles(id, dir string) (io.ReadCloser, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return nil, err
+	}
+	dir = path.Clean(dir)
+	now := time.Now()
+	buf := new(bytes.Buffer)
+	writer := tar.NewWriter(buf)
+	for name, contents := range c.files {
+		rel := strings.TrimPrefix(name, dir+"/")
+		if rel == name {
+			continue
+		}
+		header := &tar.Header{
+			Name:     rel,
+			Mode:     0644,
+			Uid:      c.spec.UID,
+			Gid:      c.spec.UID,
+			Size:     int64(len(contents)),
+			ModTime:  now,
+			Typeflag: tar.TypeReg,
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			return nil, fmt.Errorf("writing tar header: %v", err)
+		}
+		if _, err := writer.Write(contents); err != nil {
+			return nil, fmt.Errorf("writing to tar file: %v", err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("closing tar file: %v", err)
+	}
+	return ioutil.NopCloser(buf), nil
+}
+
+func (e *fakeEngine) Rename(id, name string) error {
+	e.Lock()
+	defer e.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return err
+	}
+	for _, other := range e.containers {
+		if other != c && other.spec.Name == name {
+			return errContainerExists
+		}
+	}
+	c.spec.Name = name
+	return nil
+}
+
+func (e *fakeEngine) State(id string) (*ContainerState, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return nil, err
+	}
+	return &ContainerState{Running: c.running}, nil
+}
+
+func (e *fakeEngine) List(label, value string) ([]*ContainerInfo, error) {
+	e.Lock()
+	defer e.Unlock()
+
+	var list []*ContainerInfo
+	for id, c := range e.containers {
+		if c.spec.Labels[label] == value {
+			list = append(list, &ContainerInfo{ID: id, Name: c.spec.Name, Created: c.created})
+		}
+	}
+	return list, nil
+}
+
+func (e *fakeEngine) Kill(id string) error {
+	e.Lock()
+	defer e.Unlock()
+
+	c, err := e.container(id)
+	if err != nil {
+		return err
+	}
+	c.running = false
+	return nil
+}
+
+func (e *fakeEngine) Remove(id string) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if _, err := e.container(id); err != nil {
+		return err
+	}
+	delete(e.containers, id)
+	return nil
+}
+
+func (e *fakeEngine) CreateNetwork(name string, labels map[string]string) error {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.networks[name] == nil {
+		e.networks[name] = &fakeNetwork{labels: labels, created: time.Now()}
+	}
+	return nil
+}
+
+func (e *fakeEngine) RemoveNetwork(name string) error {
+	e.Lock()
+	defer e.Unlock()
+
+	for _, c := range e.containers {
+		if c.spec.Network == name {
+			return fmt.Errorf("network %s is in use by container %s", name, c.spec.Name)
+		}
+	}
+	delete(e.networks, name)
+	return nil
+}
+
+func (e *fakeEngine) PruneNetworks(label, value string, olderThan time.Duration) error {
+	e.Lock()
+	defer e.Unlock()
+
+	inUse := make(map[string]bool)
+	for _, c := range e.containers {
+		inUse[c.spec.Network] = true
+	}
+	for name, n := range e.networks {
+		if n.labels[label] == value && !inUse[name] && time.Since(n.created) >= olderThan {
+			delete(e.networks, name)
+		}
+	}
+	return nil
+}
+
+// ImageDigest reports every image as present, with a digest derived from
+// its name unless it is named by ID.
+func (e *fakeEngine) ImageDigest(image string) (string, error) {
+	if strings.HasPrefix(image, "sha256:") {
+		return image, nil
+	}
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(image))), nil
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerParser("gotest", runAndParseGoTest)
+}
+
+// GoTestEvent is one line of output from go test -json.
+// The build events are only reported by Go 1.24 and later.
+type GoTestEvent struct {
+	Time       time.Time `json:"Time"`
+	Action     string    `json:"Action"`
+	Package    string    `json:"Package"`
+	ImportPath string    `json:"ImportPath"`
+	Test       string    `json:"Test"`
+	Elapsed    float64   `json:"Elapsed"`
+	Output     string    `json:"Output"`
+}
+
+// goTestCase gathers the events for a single test or subtest.
+type goTestCase struct {
+	pkg         string
+	name        string
+	outcome     string
+	output      []string
+	children    int
+	childFailed bool
+}
+
+// runAndParseGoTest runs a command that writes go test -json output to
+// test_detail.json and records each test in the report card. Compiler errors
+// go to stderr in older versions of Go, so stderr is treated as build output.
+func runAndParseGoTest(n *Nanny, cmd []string) {
+	filename := "test_detail.json"
+
+	_, stderr, _, status, err := n.Exec(cmd)
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error running unit tests: %v", err)
+		return
+	}
+
+	// did it end in a segfault?
+	if status > 127 {
+		n.ReportCard.LogAndFailf("Crashed with exit status %d while running unit tests", status)
+		return
+	}
+	n.ReportCard.Passed = status == 0
+
+	// parse the test results
+	jsonfiles, err := n.GetFiles([]string{filename})
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error getting unit test results")
+		return
+	}
+
+	parseGoTest(n, jsonfiles[filename], stderr.String())
+}
+
+// lines that go test adds around each test's own output
+var goTestFraming = []string{"=== RUN", "=== PAUSE", "=== CONT", "=== NAME", "--- PASS", "--- FAIL", "--- SKIP"}
+
+func parseGoTest(n *Nanny, contents []byte, stderr string) {
+	var cases []*goTestCase
+	byName := make(map[string]*goTestCase)
+	var buildOutput, packageOutput []string
+	buildFailed := false
+
+	for _, line := range bytes.Split(contents, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		event := new(GoTestEvent)
+		if err := json.Unmarshal(line, event); err != nil || event.Action == "" {
+			// anything else is probably from the build
+			buildOutput = append(buildOutput, string(line)+"\n")
+			continue
+		}
+
+		switch {
+		case event.Action == "build-output":
+			buildOutput = append(buildOutput, event.Output)
+		case event.Action == "build-fail":
+			buildFailed = true
+		case event.Test == "":
+			if event.Action == "output" {
+				packageOutput = append(packageOutput, event.Output)
+				if strings.Contains(event.Output, "[build failed]") || strings.Contains(event.Output, "[setup failed]") {
+					buildFailed = true
+				}
+			}
+		default:
+			elt := byName[goTestKey(event.Package, event.Test)]
+			if elt == nil {
+				elt = &goTestCase{pkg: event.Package, name: event.Test}
+				byName[goTestKey(event.Package, event.Test)] = elt
+				cases = append(cases, elt)
+			}
+			switch event.Action {
+			case "output":
+				if !isGoTestFraming(event.Output) {
+					elt.output = append(elt.output, event.Output)
+				}
+			case "pass":
+				elt.outcome = "passed"
+			case "fail":
+				elt.outcome = "failed"
+			case "skip":
+				elt.outcome = "skipped"
+			}
+		}
+	}
+
+	if buildFailed || len(cases) == 0 && !n.ReportCard.Passed && strings.TrimSpace(stderr) != "" {
+		details := strings.TrimSpace(strings.Join(buildOutput, "") + stderr)
+		if details == "" {
+			details = strings.TrimSpace(strings.Join(packageOutput, ""))
+		}
+		n.ReportCard.AddErrorResult("build", details, "")
+		n.ReportCard.Passed = false
+		n.ReportCard.Note = "Build failed"
+		return
+	}
+	if len(cases) == 0 {
+		n.ReportCard.LogAndFailf("No unit test results found")
+		return
+	}
+
+	// a test that panicked or never finished could not run to completion
+	var panicked []string
+	for _, elt := range cases {
+		if i := goTestPanic(elt.output); i >= 0 {
+			if panicked == nil {
+				panicked = elt.output[i:]
+			}
+			elt.output = append(elt.output[:i:i], "test panicked\n")
+			elt.outcome = "error"
+		} else if elt.outcome == "" {
+			elt.outcome = "error"
+			elt.output = append(elt.output, "test did not finish\n")
+		}
+	}
+
+	// a test with subtests is summed up by its subtests' results
+	packages := make(map[string]bool)
+	for _, elt := range cases {
+		packages[elt.pkg] = true
+		slash := strings.LastIndex(elt.name, "/")
+		if slash < 0 {
+			continue
+		}
+		if parent := byName[goTestKey(elt.pkg, elt.name[:slash])]; parent != nil {
+			parent.children++
+			parent.childFailed = parent.childFailed || elt.outcome == "failed" || elt.outcome == "error"
+		}
+	}
+
+	// form a report card
+	passed, failed, errors, skipped := 0, 0, 0, 0
+	for _, elt := range cases {
+		if elt.children > 0 && (elt.outcome == "passed" || elt.outcome == "skipped" || elt.childFailed) {
+			continue
+		}
+		name := strings.Replace(elt.name, "/", " -> ", -1)
+		if len(packages) > 1 {
+			// the same test name may appear in more than one package
+			name = elt.pkg + " -> " + name
+		}
+		details := strings.TrimRight(strings.Join(elt.output, ""), "\n")
+		switch elt.outcome {
+		case "passed":
+			passed++
+			n.ReportCard.AddPassedResult(name, "")
+		case "skipped":
+			skipped++
+			n.ReportCard.AddSkippedResult(name, details)
+		case "error":
+			errors++
+			n.ReportCard.AddErrorResult(name, details, "")
+		default:
+			failed++
+			n.ReportCard.AddFailedResult(name, details, "")
+		}
+	}
+	if i := goTestPanic(packageOutput); panicked == nil && i >= 0 {
+		panicked = packageOutput[i:]
+	}
+	if panicked != nil {
+		details := strings.TrimRight(strings.Join(panicked, ""), "\n")
+		n.ReportCard.AddErrorResult("panic", details, "")
+	}
+
+	note := fmt.Sprintf("Passed %d/%d tests in %v", passed, passed+failed+errors, time.Since(n.Start))
+	if errors > 0 {
+		note += fmt.Sprintf(", errors %d", errors)
+	}
+	if skipped > 0 {
+		note += fmt.Sprintf(", skipped %d", skipped)
+	}
+	n.ReportCard.Note = note
+	n.ReportCard.Passed = n.ReportCard.Passed && failed+errors == 0 && panicked == nil && passed > 0
+}
+
+// goTestKey identifies a test, which is only unique within its package.
+func goTestKey(pkg, test string) string {
+	return pkg + "\x00" + test
+}
+
+func isGoTestFraming(line string) bool {
+	line = strings.TrimSpace(line)
+	for _, prefix := range goTestFraming {
+		if strings.HasPrefix(line, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// goTestPanic finds the line where a panic message starts,
+// or returns -1 if there was no panic.
+func goTestPanic(output []string) int {
+	for i, line := range output {
+		if strings.HasPrefix(line, "panic: ") {
+			return i
+		}
+	}
+	return -1
+}
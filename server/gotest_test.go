@@ -0,0 +1,157 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// The streams in testdata/gotest were captured from go test -json.
+func TestParseGoTest(t *testing.T) {
+	tests := []struct {
+		parserTest
+		file   string // a captured stream, or empty to use input
+		stderr string
+		exitOK bool
+	}{
+		{
+			file: "subtests.json",
+			parserTest: parserTest{
+				name:   "subtests",
+				passed: false,
+				results: []string{
+					"passed: TestSum",
+					"passed: TestTable -> empty",
+					"passed: TestTable -> one_value",
+					"failed: TestTable -> negative",
+					"failed: TestParallel -> slow",
+					"passed: TestParallel -> fast",
+					"skipped: TestSkipped",
+				},
+				details: map[string]string{
+					"TestTable -> negative": "    sum_test.go:27: Sum([-1 -2]) = -3, want -4",
+					"TestParallel -> slow":  "    sum_test.go:40: slow finished\n    sum_test.go:41: slow failed",
+					"TestSkipped":           "    sum_test.go:49: not ready",
+				},
+				note: "Passed 4/6 tests",
+			},
+		},
+		{
+			file: "parallel.json",
+			parserTest: parserTest{
+				name:    "interleaved parallel tests",
+				passed:  false,
+				results: []string{"passed: TestAlpha", "failed: TestBeta"},
+				details: map[string]string{
+					"TestBeta": "    par_test.go:11: beta step 0\n" +
+						"    par_test.go:11: beta step 1\n" +
+						"    par_test.go:11: beta step 2\n" +
+						"    par_test.go:15: beta gave up",
+				},
+				note: "Passed 1/2 tests",
+			},
+		},
+		{
+			file: "packages.json",
+			parserTest: parserTest{
+				name:    "same test in two packages",
+				passed:  false,
+				results: []string{"passed: example.com/multi/a -> TestShared", "failed: example.com/multi/b -> TestShared"},
+				details: map[string]string{
+					"example.com/multi/b -> TestShared": "    x_test.go:10: b step 0\n" +
+						"    x_test.go:10: b step 1\n" +
+						"    x_test.go:10: b step 2\n" +
+						"    x_test.go:14: b failed",
+				},
+				note: "Passed 1/2 tests",
+			},
+		},
+		{
+			file: "nested.json",
+			parserTest: parserTest{
+				name:   "same subtests in two packages",
+				passed: false,
+				results: []string{
+					"failed: example.com/nested/a -> TestTable",
+					"passed: example.com/nested/a -> TestTable -> one",
+					"passed: example.com/nested/a -> TestTable -> two",
+					"passed: example.com/nested/b -> TestTable -> one",
+					"failed: example.com/nested/b -> TestTable -> negative",
+				},
+				details: map[string]string{
+					"example.com/nested/a -> TestTable":             "    x_test.go:9: table is missing a case",
+					"example.com/nested/b -> TestTable -> negative": "    x_test.go:8: Sum([-1]) = 1, want -1",
+				},
+				note: "Passed 3/5 tests",
+			},
+		},
+		{
+			file: "panic.json",
+			parserTest: parserTest{
+				name:    "panic",
+				passed:  false,
+				results: []string{"passed: TestFine", "error: TestPanic", "error: panic"},
+				details: map[string]string{"TestPanic": "test panicked"},
+				note:    "Passed 1/2 tests",
+			},
+		},
+		{
+			file: "build.json",
+			parserTest: parserTest{
+				name:    "build failure",
+				passed:  false,
+				results: []string{"error: build"},
+				details: map[string]string{
+					"build": "# example.com/broken [example.com/broken.test]\n./broken.go:4:13: undefined: y",
+				},
+				note: "Build failed",
+			},
+		},
+		{
+			stderr: "# example.com/broken\n./broken.go:4:13: undefined: y\n",
+			parserTest: parserTest{
+				name: "build failure from an older go",
+				input: `{"Action":"output","Package":"example.com/broken","Output":"FAIL\texample.com/broken [build failed]\n"}` + "\n" +
+					`{"Action":"fail","Package":"example.com/broken","Elapsed":0}` + "\n",
+				passed:  false,
+				results: []string{"error: build"},
+				details: map[string]string{"build": "# example.com/broken\n./broken.go:4:13: undefined: y"},
+				note:    "Build failed",
+			},
+		},
+		{
+			parserTest: parserTest{
+				name: "test that never finished",
+				input: `{"Action":"run","Package":"p","Test":"TestHang"}` + "\n" +
+					`{"Action":"output","Package":"p","Test":"TestHang","Output":"=== RUN   TestHang\n"}` + "\n",
+				passed:  false,
+				results: []string{"error: TestHang"},
+				details: map[string]string{"TestHang": "test did not finish"},
+			},
+		},
+		{
+			exitOK: true,
+			parserTest: parserTest{
+				name:   "no tests",
+				passed: false,
+				note:   "No unit test results found",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			input := []byte(test.input)
+			if test.file != "" {
+				var err error
+				if input, err = ioutil.ReadFile(filepath.Join("testdata", "gotest", test.file)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			n := newTestNanny()
+			n.ReportCard.Passed = test.exitOK
+			parseGoTest(n, input, test.stderr)
+			test.check(t, n.ReportCard)
+		})
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"log"
+	"sync"
+)
+
+// DaycareRegistrationReply is the TA's answer to a daycare registration.
+// It tells the daycare which image each problem type uses, since only the
+// TA has the problem type definitions.
+type DaycareRegistrationReply struct {
+	Images  map[string]string `json:"images"`            // problem type name to image
+	Digests map[string]string `json:"digests,omitempty"` // problem type name to the image ID it is pinned to
+}
+
+// daycareImages keeps track of the image each problem type uses,
+// as reported by the TA.
+type daycareImages struct {
+	sync.Mutex
+	images  map[string]string
+	pinned  map[string]string
+	missing map[string]bool
+}
+
+var problemTypeImages = &daycareImages{
+	images:  make(map[string]string),
+	pinned:  make(map[string]string),
+	missing: make(map[string]bool),
+}
+
+// Update records the images and pinned image IDs from a registration reply.
+// It reports whether anything changed.
+func (d *daycareImages) Update(images, pinned map[string]string) bool {
+	d.Lock()
+	defer d.Unlock()
+
+	changed := len(images) != len(d.images) || len(pinned) != len(d.pinned)
+	for name, image := range images {
+		if d.images[name] != image {
+			changed = true
+		}
+	}
+	for name, digest := range pinned {
+		if d.pinned[name] != digest {
+			changed = true
+		}
+	}
+	if changed {
+		d.images = images
+		d.pinned = pinned
+	}
+	return changed
+}
+
+// Present checks which of the given problem types have their image on this
+// daycare. It returns those problem types and the digest of each one's image.
+// A pinned problem type needs the exact image it is pinned to, whatever its
+// tag points to now. Problem types with no known image yet are left out.
+func (d *daycareImages) Present(engine ContainerEngine, problemTypes []string) ([]string, map[string]string) {
+	d.Lock()
+	images := make(map[string]string)
+	for _, name := range problemTypes {
+		if digest := d.pinned[name]; digest != "" {
+			images[name] = digest
+		} else if image := d.images[name]; image != "" {
+			images[name] = image
+		}
+	}
+	d.Unlock()
+
+	var present []string
+	digests := make(map[string]string)
+	for _, name := range problemTypes {
+		image := images[name]
+		if image == "" {
+			continue
+		}
+		digest, err := engine.ImageDigest(image)
+		if err != nil {
+			log.Printf("%v", err)
+			continue
+		}
+		d.noteMissing(name, image, digest == "")
+		if digest == "" {
+			continue
+		}
+		present = append(present, name)
+		digests[name] = digest
+	}
+	return present, digests
+}
+
+// noteMissing logs when a problem type's image goes missing or reappears.
+func (d *daycareImages) noteMissing(name, image string, missing bool) {
+	d.Lock()
+	defer d.Unlock()
+
+	if missing && !d.missing[name] {
+		log.Printf("image %s for problem type %s is not present, not offering that problem type", image, name)
+	} else if !missing && d.missing[name] {
+		log.Printf("image %s for problem type %s is now present", image, name)
+	}
+	d.missing[name] = missing
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestImagesPresent(t *testing.T) {
+	engine := newFakeEngine()
+	tagged, _ := engine.ImageDigest("codegrinder/go")
+	pinned := "sha256:0123456789abcdef"
+
+	d := &daycareImages{missing: make(map[string]bool)}
+	if !d.Update(map[string]string{"gotest": "codegrinder/go", "cinout": "codegrinder/c"}, nil) {
+		t.Errorf("Update reported no change to an empty list")
+	}
+	if !d.Update(map[string]string{"gotest": "codegrinder/go", "cinout": "codegrinder/c"}, map[string]string{"cinout": pinned}) {
+		t.Errorf("Update reported no change when a problem type was pinned")
+	}
+
+	// a pinned problem type is checked by its image ID instead of its tag
+	present, digests := d.Present(engine, []string{"cinout", "gotest", "unknown"})
+	if want := []string{"cinout", "gotest"}; !reflect.DeepEqual(present, want) {
+		t.Errorf("present is %q, want %q", present, want)
+	}
+	if want := map[string]string{"cinout": pinned, "gotest": tagged}; !reflect.DeepEqual(digests, want) {
+		t.Errorf("digests are %q, want %q", digests, want)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// locationExtractor finds where a test failed in its details, using the
+// conventions of one language's compiler, test framework, or runtime.
+// The pattern must capture the file and line, and may capture the column
+// and the function; an index of zero means the pattern has no such group.
+type locationExtractor struct {
+	pattern  *regexp.Regexp
+	file     int
+	line     int
+	column   int
+	function int
+}
+
+// studentPath strips the container's home directory from an absolute path.
+const studentPath = `(?:/home/student/)?`
+
+var locationExtractors = []*locationExtractor{
+	// go test failures, compiler errors, and panics
+	{pattern: regexp.MustCompile(`(?m)^\s+(\S+_test\.go):(\d+):`), file: 1, line: 2},
+	{pattern: regexp.MustCompile(`(?m)^(?:\./)?([^\s:]+\.go):(\d+):(\d+):`), file: 1, line: 2, column: 3},
+	{pattern: regexp.MustCompile(`(?m)^(\S+?)\(.*\)\n\t/home/student/(\S+\.go):(\d+)`), file: 2, line: 3, function: 1},
+
+	// rust panics and compiler errors
+	{pattern: regexp.MustCompile(`thread '([^']+)' panicked at (?:'.*', )?` + studentPath + `([^\s:]+\.rs):(\d+):(\d+)`), file: 2, line: 3, column: 4, function: 1},
+	{pattern: regexp.MustCompile(`--> ([^\s:]+\.rs):(\d+):(\d+)`), file: 1, line: 2, column: 3},
+
+	// C and C++: gtest, compiler errors, and sanitizer reports, skipping
+	// stack frames outside the student's directory
+	{pattern: regexp.MustCompile(`(?m)^(tests/[^:/]*):(\d+)`), file: 1, line: 2},
+	{pattern: regexp.MustCompile(`(?m)^` + studentPath + `([^\s:/][^\s:]*\.(?:c|cc|cpp|h|hpp)):(\d+):(\d+): (?:runtime error|error)`), file: 1, line: 2, column: 3},
+	{pattern: regexp.MustCompile(`#\d+ 0x[0-9a-f]+ in (\S+) ` + studentPath + `([^\s:/][^\s:]*\.(?:c|cc|cpp|h|hpp)):(\d+)(?::(\d+))?`), file: 2, line: 3, column: 4, function: 1},
+
+	// python tracebacks, skipping frames outside the student's directory
+	{pattern: regexp.MustCompile(`File "` + studentPath + `([^"/][^"]*)", line (\d+)(?:, in (\w+))?`), file: 1, line: 2, function: 3},
+
+	// SWI-Prolog errors and warnings, with the plunit test name if given
+	{pattern: regexp.MustCompile(`(?:ERROR|Warning): ` + studentPath + `([^\s:]+\.pl):(\d+):(?:(\d+):)?(?:\s+test (\w+))?`), file: 1, line: 2, column: 3, function: 4},
+
+	// Standard ML from SML/NJ and Poly/ML, and from MLton
+	{pattern: regexp.MustCompile(studentPath + `([^\s:]+\.(?:sml|sig|fun)):(\d+)(?:\.(\d+))?`), file: 1, line: 2, column: 3},
+	{pattern: regexp.MustCompile(`(?:Error|Warning): ([^\s:]+\.(?:sml|sig|fun)) (\d+)\.(\d+)`), file: 1, line: 2, column: 3},
+
+	// TypeScript compiler errors and JavaScript stack traces
+	{pattern: regexp.MustCompile(`(?m)^([^\s(]+\.tsx?)\((\d+),(\d+)\): error`), file: 1, line: 2, column: 3},
+	{pattern: regexp.MustCompile(`at (?:(\S+) \()?` + studentPath + `([^\s:()/][^\s:()]*\.[jt]sx?):(\d+):(\d+)`), file: 2, line: 3, column: 4, function: 1},
+}
+
+// findLocation returns the earliest location any extractor finds in the
+// text, or nil if none does. Files in installed packages or outside the
+// student's directory are passed over.
+func findLocation(text string) *ReportCardLocation {
+	var best *ReportCardLocation
+	bestStart := -1
+	for _, extractor := range locationExtractors {
+		for _, groups := range extractor.pattern.FindAllStringSubmatchIndex(text, -1) {
+			if bestStart >= 0 && groups[0] >= bestStart {
+				break
+			}
+			group := func(n int) string {
+				if n == 0 || groups[2*n] < 0 {
+					return ""
+				}
+				return text[groups[2*n]:groups[2*n+1]]
+			}
+			file := group(extractor.file)
+			if strings.Contains(file, "node_modules/") || file == ".." || strings.HasPrefix(file, "../") {
+				continue
+			}
+			loc := &ReportCardLocation{
+				File:     file,
+				Function: group(extractor.function),
+			}
+			loc.Line, _ = strconv.Atoi(group(extractor.line))
+			loc.Column, _ = strconv.Atoi(group(extractor.column))
+			best, bestStart = loc, groups[0]
+			break
+		}
+	}
+	return best
+}
+
+// parseContext reads a context string in file:line or file:line:column form.
+func parseContext(context string) *ReportCardLocation {
+	parts := strings.Split(context, ":")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" {
+		return nil
+	}
+	loc := &ReportCardLocation{File: parts[0]}
+	var err error
+	if loc.Line, err = strconv.Atoi(parts[1]); err != nil || loc.Line < 1 {
+		return nil
+	}
+	if len(parts) == 3 {
+		if loc.Column, err = strconv.Atoi(parts[2]); err != nil || loc.Column < 1 {
+			return nil
+		}
+	}
+	return loc
+}
+
+// locateResults fills in the location of each failed result, using the
+// context the parser found or else searching the details. Results with a
+// location but no context get one, so older clients can still show it.
+func locateResults(card *ReportCard) {
+	for _, elt := range card.Results {
+		if elt.Outcome != "failed" && elt.Outcome != "error" {
+			continue
+		}
+		if elt.Location == nil && elt.Context != "" {
+			elt.Location = parseContext(elt.Context)
+		}
+		if elt.Location == nil {
+			elt.Location = findLocation(elt.Details)
+		}
+		if elt.Location != nil && elt.Context == "" {
+			elt.Context = elt.Location.Position()
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"testing"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+func TestFindLocation(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want *ReportCardLocation
+	}{
+		{
+			name: "go test failure",
+			text: "    sum_test.go:27: Sum([-1 -2]) = -3, want -4",
+			want: &ReportCardLocation{File: "sum_test.go", Line: 27},
+		},
+		{
+			name: "go compiler error",
+			text: "# example.com/broken [example.com/broken.test]\n./broken.go:4:13: undefined: y",
+			want: &ReportCardLocation{File: "broken.go", Line: 4, Column: 13},
+		},
+		{
+			name: "go panic",
+			text: "panic: assignment to entry in nil map [recovered, repanicked]\n\n" +
+				"goroutine 7 [running]:\n" +
+				"testing.tRunner.func1.2({0x6b6dd0, 0x6ef0e0})\n" +
+				"\t/usr/local/go/src/testing/testing.go:2123 +0x232\n" +
+				"panic({0x6b6dd0?, 0x6ef0e0?})\n" +
+				"\t/usr/local/go/src/runtime/panic.go:859 +0x125\n" +
+				"example.com/boom.TestPanic(0x24ff12848488?)\n" +
+				"\t/home/student/boom_test.go:9 +0x28\n",
+			want: &ReportCardLocation{File: "boom_test.go", Line: 9, Function: "example.com/boom.TestPanic"},
+		},
+		{
+			name: "rust panic",
+			text: "thread 'tests::adds' panicked at src/lib.rs:10:5:\n" +
+				"assertion `left == right` failed\n  left: 3\n right: 4\n" +
+				"note: run with `RUST_BACKTRACE=1` environment variable to display a backtrace",
+			want: &ReportCardLocation{File: "src/lib.rs", Line: 10, Column: 5, Function: "tests::adds"},
+		},
+		{
+			name: "rust panic before 1.73",
+			text: "thread 'tests::adds' panicked at 'assertion failed: `(left == right)`', /home/student/src/lib.rs:10:5",
+			want: &ReportCardLocation{File: "src/lib.rs", Line: 10, Column: 5, Function: "tests::adds"},
+		},
+		{
+			name: "rust compiler error",
+			text: "error[E0425]: cannot find value `y` in this scope\n --> src/lib.rs:2:9\n  |\n2 |     x * y\n  |         ^ not found in this scope",
+			want: &ReportCardLocation{File: "src/lib.rs", Line: 2, Column: 9},
+		},
+		{
+			name: "address sanitizer",
+			text: "=================================================================\n" +
+				"==12==ERROR: AddressSanitizer: heap-buffer-overflow on address 0x602000000014 at pc 0x4011d6 bp 0x7ffd1c5e0e20 sp 0x7ffd1c5e0e18\n" +
+				"WRITE of size 4 at 0x602000000014 thread T0\n" +
+				"    #0 0x4011d6 in fill /home/student/main.c:9:3\n" +
+				"    #1 0x401234 in main /home/student/main.c:20:5\n" +
+				"    #2 0x7f3a1c0d8d8f in __libc_start_call_main ../sysdeps/nptl/libc_start_call_main.h:58\n",
+			want: &ReportCardLocation{File: "main.c", Line: 9, Column: 3, Function: "fill"},
+		},
+		{
+			name: "sanitizer frame in a library first",
+			text: "==7==ERROR: AddressSanitizer: attempting double-free on 0x602000000010 in thread T0:\n" +
+				"    #0 0x7f0c4a8b5517 in free ../../../../src/libsanitizer/asan/asan_malloc_linux.cpp:127\n" +
+				"    #1 0x401196 in cleanup /home/student/list.c:31\n",
+			want: &ReportCardLocation{File: "list.c", Line: 31, Function: "cleanup"},
+		},
+		{
+			name: "undefined behavior sanitizer",
+			text: "main.c:5:12: runtime error: signed integer overflow: 2147483647 + 1 cannot be represented in type 'int'",
+			want: &ReportCardLocation{File: "main.c", Line: 5, Column: 12},
+		},
+		{
+			name: "gtest",
+			text: "tests/test_sum.cpp:12: Failure\nExpected equality of these values:\n  sum(1, 2)\n    Which is: 4\n  3",
+			want: &ReportCardLocation{File: "tests/test_sum.cpp", Line: 12},
+		},
+		{
+			name: "python traceback",
+			text: "Traceback (most recent call last):\n" +
+				"  File \"/usr/lib/python3.11/unittest/case.py\", line 57, in testPartExecutor\n" +
+				"    yield\n" +
+				"  File \"/home/student/tests/test_sum.py\", line 8, in test_negative\n" +
+				"    self.assertEqual(total([-1]), -2)\n" +
+				"AssertionError: -1 != -2",
+			want: &ReportCardLocation{File: "tests/test_sum.py", Line: 8, Function: "test_negative"},
+		},
+		{
+			name: "prolog plunit failure",
+			text: "ERROR: /home/student/tests.pl:12:\n\ttest append_empty: assertion failed\n\tAssertion: append([],[],[a])",
+			want: &ReportCardLocation{File: "tests.pl", Line: 12, Function: "append_empty"},
+		},
+		{
+			name: "prolog syntax error",
+			text: "ERROR: /home/student/solution.pl:7:15: Syntax error: Operator expected",
+			want: &ReportCardLocation{File: "solution.pl", Line: 7, Column: 15},
+		},
+		{
+			name: "sml/nj error",
+			text: "[opening solution.sml]\nsolution.sml:12.5-12.20 Error: unbound variable or constructor: fo",
+			want: &ReportCardLocation{File: "solution.sml", Line: 12, Column: 5},
+		},
+		{
+			name: "poly/ml error",
+			text: "solution.sml:4: error: Value or constructor (fo) has not been declared",
+			want: &ReportCardLocation{File: "solution.sml", Line: 4},
+		},
+		{
+			name: "mlton error",
+			text: "Error: solution.sml 3.7-3.9.\n  Undefined variable: y.\ncompilation aborted: parseAndElaborate reported errors",
+			want: &ReportCardLocation{File: "solution.sml", Line: 3, Column: 7},
+		},
+		{
+			name: "typescript compiler error",
+			text: "src/index.ts(4,10): error TS2322: Type 'string' is not assignable to type 'number'.",
+			want: &ReportCardLocation{File: "src/index.ts", Line: 4, Column: 10},
+		},
+		{
+			name: "jest failure",
+			text: "expect(received).toBe(expected) // Object.is equality\n\n" +
+				"Expected: 3\nReceived: 4\n\n" +
+				"    at Object.<anonymous> (/home/student/tests/sum.test.ts:5:20)\n" +
+				"    at Promise.then.completed (/home/student/node_modules/jest-circus/build/utils.js:298:28)",
+			want: &ReportCardLocation{File: "tests/sum.test.ts", Line: 5, Column: 20, Function: "Object.<anonymous>"},
+		},
+		{
+			name: "node stack trace from a package first",
+			text: "TypeError: Cannot read properties of undefined (reading 'length')\n" +
+				"    at parse (/home/student/node_modules/csv-parse/lib/index.js:42:17)\n" +
+				"    at readRows (/home/student/src/rows.ts:14:9)",
+			want: &ReportCardLocation{File: "src/rows.ts", Line: 14, Column: 9, Function: "readRows"},
+		},
+		{
+			name: "no location",
+			text: "expected 3 but got 4",
+		},
+	}
+
+	for _, test := range tests {
+		got := findLocation(test.text)
+		switch {
+		case got == nil && test.want == nil:
+		case got == nil:
+			t.Errorf("%s: found no location, want %+v", test.name, *test.want)
+		case test.want == nil:
+			t.Errorf("%s: found %+v, want none", test.name, *got)
+		case *got != *test.want:
+			t.Errorf("%s: found %+v, want %+v", test.name, *got, *test.want)
+		}
+	}
+}
+
+func TestLocateResults(t *testing.T) {
+	card := NewReportCard()
+	card.AddPassedResult("passes", "main.go:1:1: not a failure")
+	card.AddFailedResult("has context", "", "sum_test.go:27")
+	card.AddFailedResult("has details", "./broken.go:4:13: undefined: y", "")
+	card.AddErrorResult("bad context", "src/lib.rs:10:5", "not a position")
+	card.AddFailedResult("nothing", "expected 3 but got 4", "")
+	locateResults(card)
+
+	want := []struct {
+		location *ReportCardLocation
+		context  string
+	}{
+		{nil, ""},
+		{&ReportCardLocation{File: "sum_test.go", Line: 27}, "sum_test.go:27"},
+		{&ReportCardLocation{File: "broken.go", Line: 4, Column: 13}, "broken.go:4:13"},
+		{nil, "not a position"},
+		{nil, ""},
+	}
+	for i, elt := range card.Results {
+		switch {
+		case (elt.Location == nil) != (want[i].location == nil):
+			t.Errorf("%s: location is %+v, want %+v", elt.Name, elt.Location, want[i].location)
+		case elt.Location != nil && *elt.Location != *want[i].location:
+			t.Errorf("%s: location is %+v, want %+v", elt.Name, *elt.Location, *want[i].location)
+		}
+		if elt.Context != want[i].context {
+			t.Errorf("%s: context is %q, want %q", elt.Name, elt.Context, want[i].context)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// parsers maps each parser name a problem type action or stage can give to
+// the function that runs its command and fills in the nanny's report card.
+// Parsers add themselves with registerParser when the program starts.
+var parsers = make(map[string]func(n *Nanny, cmd []string))
+
+// registerParser adds a parser to the registry. The empty name is the
+// default for actions with no parser.
+func registerParser(name string, run func(n *Nanny, cmd []string)) {
+	if _, exists := parsers[name]; exists {
+		panic(fmt.Sprintf("parser %q registered twice", name))
+	}
+	parsers[name] = run
+}
+
+// parserNames lists the registered parsers for error messages.
+func parserNames() string {
+	var names []string
+	for name := range parsers {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+func init() {
+	registerParser("", runAndCheckStatus)
+}
+
+// runAndCheckStatus runs a command and lets its exit status alone
+// decide the outcome.
+func runAndCheckStatus(n *Nanny, cmd []string) {
+	_, _, _, status, err := n.Exec(cmd)
+	if err != nil {
+		n.ReportCard.LogAndFailf("%q exec error: %v", strings.Join(cmd, " "), err)
+	} else if status != 0 {
+		err := fmt.Errorf("%q failed with exit status %d", strings.Join(cmd, " "), status)
+		n.ReportCard.LogAndFailf("%v", err)
+	}
+}
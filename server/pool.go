@@ -0,0 +1,350 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"sync"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// warmContainerMaxIdle is how long a pre-started container may sit unused
+// before it is replaced. It is added to the backstop sleep of warm containers
+// so an idle container never outlives its own time limit.
+const warmContainerMaxIdle = 10 * time.Minute
+
+// warmPoolSweepInterval is how often stale warm containers are replaced.
+const warmPoolSweepInterval = time.Minute
+
+// warmPool is the pool of pre-started containers, or nil if it is disabled.
+var warmPool *containerPool
+
+// containerPool keeps a number of pristine, pre-started containers for each
+// problem type so actions do not wait for a cold start. A container is used
+// once and then destroyed, and a fresh one is started in its place.
+//
+// At startup the daycare fetches each problem type it serves from the TA
+// and warms it up for its grade action. After that, each type is kept warm
+// with the limits of the most recent action for that type. Warm
+// containers count against the daycare's capacity: idle containers are
+// only started when there is room for them alongside the active ones.
+type containerPool struct {
+	sync.Mutex
+	engine   ContainerEngine
+	size     int
+	capacity int
+	types    []string
+	specs    map[string]*ContainerSpec
+	idle     map[string][]*warmContainer
+	starting map[string]int
+	active   int
+	serial   int
+	closed   bool
+}
+
+type warmContainer struct {
+	id      string
+	spec    *ContainerSpec
+	started time.Time
+}
+
+func newContainerPool(engine ContainerEngine, size, capacity int, types []string) *containerPool {
+	return &containerPool{
+		engine:   engine,
+		size:     size,
+		capacity: capacity,
+		types:    types,
+		specs:    make(map[string]*ContainerSpec),
+		idle:     make(map[string][]*warmContainer),
+		starting: make(map[string]int),
+	}
+}
+
+// writesVolume reports whether a container mounts any volume read-write.
+func writesVolume(spec *ContainerSpec) bool {
+	for _, volume := range spec.Volumes {
+		if !volume.ReadOnly {
+			return true
+		}
+	}
+	return false
+}
+
+// sameSpec reports whether a warm container started from spec a can be
+// used for a request for spec b. Names are ignored.
+func sameSpec(a, b *ContainerSpec) bool {
+	x, y := *a, *b
+	x.Name, y.Name = "", ""
+	return reflect.DeepEqual(x, y)
+}
+
+// acquire records that a container is about to be used for the given problem
+// type and returns the ID of a matching warm container, or "" if the caller
+// must start one itself. Every call must be paired with a call to release.
+func (p *containerPool) acquire(problemType string, spec *ContainerSpec) string {
+	p.Lock()
+	defer p.Unlock()
+
+	p.active++
+	if spec.Network != "" || writesVolume(spec) {
+		// containers on a network of their own cannot be started ahead of time,
+		// and ones that write to a shared volume must not be
+		p.makeRoom()
+		return ""
+	}
+	remembered := *spec
+	p.specs[problemType] = &remembered
+
+	// look for a matching container that has not been idle too long
+	var found string
+	var keep []*warmContainer
+	for _, elt := range p.idle[problemType] {
+		switch {
+		case time.Since(elt.started) >= warmContainerMaxIdle:
+			go p.destroy(elt.id)
+		case found == "" && sameSpec(elt.spec, spec):
+			found = elt.id
+		default:
+			// containers for other actions are kept until they go stale
+			keep = append(keep, elt)
+		}
+	}
+	p.idle[problemType] = keep
+	if found != "" {
+		return found
+	}
+
+	p.makeRoom()
+	return ""
+}
+
+// makeRoom evicts the oldest idle container if a cold start would push the
+// daycare over capacity. The lock must be held.
+func (p *containerPool) makeRoom() {
+	if p.total() > p.capacity {
+		var oldestType string
+		var oldest *warmContainer
+		for name, list := range p.idle {
+			if len(list) > 0 && (oldest == nil || list[0].started.Before(oldest.started)) {
+				oldestType, oldest = name, list[0]
+			}
+		}
+		if oldest != nil {
+			p.idle[oldestType] = p.idle[oldestType][1:]
+			go p.destroy(oldest.id)
+		}
+	}
+}
+
+// release records that an acquired container has been destroyed,
+// and starts replacements in the background.
+func (p *containerPool) release() {
+	p.Lock()
+	p.active--
+	p.Unlock()
+	go p.refill()
+}
+
+// total counts active, idle, and starting containers. The lock must be held.
+func (p *containerPool) total() int {
+	n := p.active
+	for _, list := range p.idle {
+		n += len(list)
+	}
+	for _, count := range p.starting {
+		n += count
+	}
+	return n
+}
+
+// refill starts warm containers until every known problem type has its
+// quota or the daycare is at capacity.
+func (p *containerPool) refill() {
+	for {
+		p.Lock()
+		var problemType string
+		var spec *ContainerSpec
+		for _, name := range p.types {
+			if p.specs[name] != nil && p.ready(name)+p.starting[name] < p.size {
+				problemType, spec = name, p.specs[name]
+				break
+			}
+		}
+		if p.closed || spec == nil || p.total() >= p.capacity && !p.evictOutdated() {
+			p.Unlock()
+			return
+		}
+		p.starting[problemType]++
+		p.serial++
+		warmSpec := *spec
+		warmSpec.Name = fmt.Sprintf("warm-%s-%d", problemType, p.serial)
+		p.Unlock()
+
+		// stretch the backstop timeout to cover the time spent idle
+		if len(warmSpec.Command) == 2 && warmSpec.Command[0] == "/bin/sleep" {
+			if d, err := time.ParseDuration(warmSpec.Command[1]); err == nil {
+				warmSpec.Command = []string{"/bin/sleep", fmt.Sprintf("%ds", int64((d+warmContainerMaxIdle)/time.Second))}
+			}
+		}
+
+		id, err := p.engine.Create(&warmSpec)
+		if err == errContainerExists {
+			// left over from an earlier run of the daycare
+			if err = p.engine.Remove(warmSpec.Name); err == nil {
+				id, err = p.engine.Create(&warmSpec)
+			}
+		}
+
+		p.Lock()
+		p.starting[problemType]--
+		if err != nil {
+			p.Unlock()
+			log.Printf("error starting warm container for %s: %v", problemType, err)
+			return
+		}
+		p.idle[problemType] = append(p.idle[problemType], &warmContainer{id: id, spec: spec, started: time.Now()})
+		p.Unlock()
+	}
+}
+
+// warmup fetches the problem types this daycare serves from the TA and
+// starts warm containers for them, so the first run of each type after
+// the daycare starts does not wait for a cold start. A type that has
+// already been used keeps the limits of its most recent request.
+func (p *containerPool) warmup() {
+	for _, name := range p.types {
+		problemType, err := fetchProblemType(name)
+		if err != nil {
+			log.Printf("error fetching problem type %s to warm containers: %v", name, err)
+			continue
+		}
+		action := warmAction(problemType)
+		if action == nil {
+			continue
+		}
+		spec, err := containerSpecFor(problemType, action, newLimits(action), "")
+		if err != nil {
+			log.Printf("error preparing warm containers for %s: %v", name, err)
+			continue
+		}
+		p.Lock()
+		if p.specs[name] == nil {
+			p.specs[name] = spec
+		}
+		p.Unlock()
+	}
+	p.refill()
+}
+
+// warmAction picks the action to warm a problem type for: grade if it has
+// one, or else the first action by name. Warmup actions write to the
+// dependency cache, so they are never run in warm containers.
+func warmAction(problemType *ProblemType) *ProblemTypeAction {
+	if action := problemType.Actions["grade"]; action != nil && !action.Warmup {
+		return action
+	}
+	var names []string
+	for name, action := range problemType.Actions {
+		if !action.Warmup {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	sort.Strings(names)
+	return problemType.Actions[names[0]]
+}
+
+// fetchProblemType gets a problem type from the TA.
+func fetchProblemType(name string) (*ProblemType, error) {
+	res, err := daycareGet("/daycare_problem_types/"+name, name)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(res.Body)
+		return nil, fmt.Errorf("unexpected status %s: %s", res.Status, body)
+	}
+	problemType := new(ProblemType)
+	if err := json.NewDecoder(res.Body).Decode(problemType); err != nil {
+		return nil, fmt.Errorf("decoding problem type: %v", err)
+	}
+	return problemType, nil
+}
+
+// ready counts idle containers matching the current limits for a problem
+// type. The lock must be held.
+func (p *containerPool) ready(problemType string) int {
+	n := 0
+	for _, elt := range p.idle[problemType] {
+		if sameSpec(elt.spec, p.specs[problemType]) {
+			n++
+		}
+	}
+	return n
+}
+
+// evictOutdated destroys an idle container whose limits no longer match the
+// most recent request for its problem type. It reports whether one was found.
+// The lock must be held.
+func (p *containerPool) evictOutdated() bool {
+	for name, list := range p.idle {
+		for i, elt := range list {
+			if !sameSpec(elt.spec, p.specs[name]) {
+				p.idle[name] = append(list[:i:i], list[i+1:]...)
+				go p.destroy(elt.id)
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Close stops starting new warm containers and removes the idle ones.
+func (p *containerPool) Close() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.closed = true
+	for name, list := range p.idle {
+		for _, elt := range list {
+			p.destroy(elt.id)
+		}
+		delete(p.idle, name)
+	}
+}
+
+// destroy removes a warm container that will not be used.
+func (p *containerPool) destroy(id string) {
+	if err := p.engine.Remove(id); err != nil {
+		log.Printf("error removing warm container: %v", err)
+	}
+}
+
+// sweep periodically replaces warm containers that have been idle too long.
+func (p *containerPool) sweep() {
+	for range time.Tick(warmPoolSweepInterval) {
+		p.Lock()
+		for name, list := range p.idle {
+			var keep []*warmContainer
+			for _, elt := range list {
+				if time.Since(elt.started) < warmContainerMaxIdle {
+					keep = append(keep, elt)
+				} else {
+					go p.destroy(elt.id)
+				}
+			}
+			p.idle[name] = keep
+		}
+		p.Unlock()
+		p.refill()
+	}
+}
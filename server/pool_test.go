@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// poolEngine is a fake engine that checks the pool never starts a warm
+// container that would put the daycare over capacity.
+type poolEngine struct {
+	*fakeEngine
+	t    *testing.T
+	pool *containerPool
+}
+
+func (e *poolEngine) Create(spec *ContainerSpec) (string, error) {
+	e.pool.Lock()
+	total := e.pool.total()
+	e.pool.Unlock()
+	if total > e.pool.capacity {
+		e.t.Errorf("starting %s with %d containers counted, capacity is %d", spec.Name, total, e.pool.capacity)
+	}
+	return e.fakeEngine.Create(spec)
+}
+
+// newTestPool returns a pool on a fake engine that checks its capacity.
+func newTestPool(t *testing.T, size, capacity int, types ...string) (*containerPool, *fakeEngine) {
+	engine := &poolEngine{fakeEngine: newFakeEngine(), t: t}
+	engine.pool = newContainerPool(engine, size, capacity, types)
+	t.Cleanup(engine.pool.Close)
+	return engine.pool, engine.fakeEngine
+}
+
+// poolSpec describes a container for a problem type with a CPU limit.
+func poolSpec(cpu int64) *ContainerSpec {
+	return &ContainerSpec{
+		Name:    "nanny-1",
+		Image:   "codegrinder/fake",
+		Command: []string{"/bin/sleep", "20s"},
+		MaxCPU:  cpu,
+	}
+}
+
+// idleCount counts the idle containers for a problem type.
+func idleCount(p *containerPool, problemType string) int {
+	p.Lock()
+	defer p.Unlock()
+	return len(p.idle[problemType])
+}
+
+// containerCount counts the containers that exist on a fake engine.
+func containerCount(e *fakeEngine) int {
+	e.Lock()
+	defer e.Unlock()
+	return len(e.containers)
+}
+
+// waitFor waits for a condition that background work should bring about.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// checkTotal checks the pool's active, idle, and starting containers
+// against its capacity and returns the total.
+func checkTotal(t *testing.T, p *containerPool) int {
+	t.Helper()
+	p.Lock()
+	defer p.Unlock()
+	total := p.total()
+	if total > p.capacity {
+		t.Errorf("%d containers counted, capacity is %d", total, p.capacity)
+	}
+	return total
+}
+
+func TestPoolCapacity(t *testing.T) {
+	p, engine := newTestPool(t, 2, 3, "a", "b")
+	a, b := poolSpec(10), poolSpec(20)
+
+	// the first run of a type is a cold start, and then it is kept warm
+	if id := p.acquire("a", a); id != "" {
+		t.Fatalf("acquire returned warm container %s from an empty pool", id)
+	}
+	p.release()
+	waitFor(t, "two warm containers for a", func() bool { return idleCount(p, "a") == 2 })
+	checkTotal(t, p)
+
+	// a cold start for b fits alongside the idle containers for a
+	if id := p.acquire("b", b); id != "" {
+		t.Fatalf("acquire returned warm container %s for a new type", id)
+	}
+	if total := checkTotal(t, p); total != 3 {
+		t.Errorf("%d containers counted, want 3", total)
+	}
+
+	// a warm container for a is handed over, and removed once it is used
+	id := p.acquire("a", a)
+	if id == "" {
+		t.Fatalf("acquire found no warm container for a")
+	}
+	engine.Remove(id)
+
+	// a second cold start for b evicts the remaining idle container for a
+	if id := p.acquire("b", b); id != "" {
+		t.Fatalf("acquire returned warm container %s for b", id)
+	}
+	if n := idleCount(p, "a"); n != 0 {
+		t.Errorf("%d idle containers for a, want 0 after eviction", n)
+	}
+	if total := checkTotal(t, p); total != 3 {
+		t.Errorf("%d containers counted, want 3", total)
+	}
+
+	// once the runs finish, the pool refills up to capacity and no further
+	for i := 0; i < 3; i++ {
+		p.release()
+	}
+	waitFor(t, "the pool to refill", func() bool {
+		return idleCount(p, "a")+idleCount(p, "b") == 3
+	})
+	waitFor(t, "evicted containers to be removed", func() bool { return containerCount(engine) == 3 })
+	checkTotal(t, p)
+}
+
+func TestPoolSpecMismatch(t *testing.T) {
+	p, engine := newTestPool(t, 2, 2, "a")
+	old, updated := poolSpec(10), poolSpec(20)
+
+	p.acquire("a", old)
+	p.release()
+	waitFor(t, "two warm containers", func() bool { return idleCount(p, "a") == 2 })
+
+	// different limits cannot use the warm containers
+	if id := p.acquire("a", updated); id != "" {
+		t.Errorf("acquire returned warm container %s for different limits", id)
+	}
+
+	// at capacity, outdated containers are replaced with ones for the new limits
+	p.release()
+	waitFor(t, "warm containers for the new limits", func() bool {
+		p.Lock()
+		defer p.Unlock()
+		return p.ready("a") == 2
+	})
+	waitFor(t, "outdated containers to be removed", func() bool { return containerCount(engine) == 2 })
+	if id := p.acquire("a", updated); id == "" {
+		t.Errorf("acquire found no warm container for the new limits")
+	}
+	p.release()
+}
+
+func TestPoolStale(t *testing.T) {
+	p, engine := newTestPool(t, 1, 2, "a")
+	spec := poolSpec(10)
+
+	p.acquire("a", spec)
+	p.release()
+	waitFor(t, "a warm container", func() bool { return idleCount(p, "a") == 1 })
+
+	// a container idle too long is destroyed instead of used
+	p.Lock()
+	stale := p.idle["a"][0]
+	stale.started = stale.started.Add(-warmContainerMaxIdle)
+	p.Unlock()
+	if id := p.acquire("a", spec); id != "" {
+		t.Errorf("acquire returned stale container %s", id)
+	}
+	waitFor(t, "the stale container to be removed", func() bool {
+		engine.Lock()
+		defer engine.Unlock()
+		return engine.containers[stale.id] == nil
+	})
+	p.release()
+}
+
+func TestPoolClose(t *testing.T) {
+	p, engine := newTestPool(t, 2, 4, "a")
+	spec := poolSpec(10)
+
+	p.acquire("a", spec)
+	p.release()
+	waitFor(t, "two warm containers", func() bool { return idleCount(p, "a") == 2 })
+
+	p.Close()
+	if n := containerCount(engine); n != 0 {
+		t.Errorf("%d containers left after Close", n)
+	}
+
+	// runs still work, but nothing is started in the background
+	if id := p.acquire("a", spec); id != "" {
+		t.Errorf("acquire returned warm container %s after Close", id)
+	}
+	p.release()
+	p.refill()
+	if n := containerCount(engine); n != 0 {
+		t.Errorf("%d containers started after Close", n)
+	}
+}
+
+func TestPoolWarmup(t *testing.T) {
+	// serve problem types the way the TA does
+	ta := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name := strings.TrimPrefix(r.URL.Path, "/daycare_problem_types/")
+		problemType := &ProblemType{
+			Name:  name,
+			Image: "codegrinder/" + name,
+			Actions: map[string]*ProblemTypeAction{
+				"grade":   {ProblemType: name, Action: "grade", MaxCPU: 10},
+				"install": {ProblemType: name, Action: "install", MaxCPU: 60, Warmup: true},
+			},
+		}
+		json.NewEncoder(w).Encode(problemType)
+	}))
+	defer ta.Close()
+	transport, hostname := http.DefaultTransport, Config.TAHostname
+	defer func() { http.DefaultTransport, Config.TAHostname = transport, hostname }()
+	http.DefaultTransport, Config.TAHostname = ta.Client().Transport, ta.Listener.Addr().String()
+
+	// a type that has already been used keeps its own limits
+	p, _ := newTestPool(t, 1, 4, "a", "b")
+	used := poolSpec(30)
+	p.acquire("b", used)
+	p.release()
+	waitFor(t, "a warm container for b", func() bool { return idleCount(p, "b") == 1 })
+
+	p.warmup()
+	if n := idleCount(p, "a"); n != 1 {
+		t.Fatalf("%d warm containers for a after warmup, want 1", n)
+	}
+	p.Lock()
+	defer p.Unlock()
+	if spec := p.specs["a"]; spec.Image != "codegrinder/a" || spec.MaxCPU != 10 {
+		t.Errorf("a warmed with image %s and CPU limit %d, want the grade action", spec.Image, spec.MaxCPU)
+	}
+	if !sameSpec(p.specs["b"], used) {
+		t.Errorf("warmup replaced the limits for b")
+	}
+}
+
+func TestWarmAction(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions []*ProblemTypeAction
+		want    string
+	}{
+		{
+			name:    "grade",
+			actions: []*ProblemTypeAction{{Action: "test"}, {Action: "grade"}, {Action: "debug"}},
+			want:    "grade",
+		},
+		{
+			name:    "no grade",
+			actions: []*ProblemTypeAction{{Action: "test"}, {Action: "run"}, {Action: "debug"}},
+			want:    "debug",
+		},
+		{
+			name:    "warmup grade",
+			actions: []*ProblemTypeAction{{Action: "grade", Warmup: true}, {Action: "test"}},
+			want:    "test",
+		},
+		{
+			name:    "skip warmup",
+			actions: []*ProblemTypeAction{{Action: "install", Warmup: true}, {Action: "test"}},
+			want:    "test",
+		},
+		{
+			name:    "only warmup",
+			actions: []*ProblemTypeAction{{Action: "install", Warmup: true}},
+			want:    "",
+		},
+	}
+
+	for _, test := range tests {
+		problemType := &ProblemType{Name: "fake", Actions: make(map[string]*ProblemTypeAction)}
+		for _, action := range test.actions {
+			problemType.Actions[action.Action] = action
+		}
+		got := ""
+		if action := warmAction(problemType); action != nil {
+			got = action.Action
+		}
+		if got != test.want {
+			t.Errorf("%s: warmAction picked %q, want %q", test.name, got, test.want)
+		}
+	}
+}
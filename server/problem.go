@@ -3,10 +3,12 @@ package main
 import (
 	"database/sql"
 	"io/ioutil"
+	"log"
 	"net/http"
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/render"
@@ -49,6 +51,71 @@ func GetProblemType(w http.ResponseWriter, tx *sql.Tx, params martini.Params, re
 	render.JSON(http.StatusOK, problemType)
 }
 
+// GetDaycareProblemType handles a request to /daycare_problem_types/:problem_type,
+// returning a problem type to a daycare so it can start warm containers for
+// it. The request must be signed with the daycare secret.
+func GetDaycareProblemType(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, render render.Render) {
+	name := params["problem_type"]
+	if !checkDaycareRequest(w, r, name) {
+		return
+	}
+
+	problemType, err := getProblemType(tx, name)
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, problemType)
+}
+
+// PutProblemTypeImageDigest handles a request to /problem_types/:name/image_digest,
+// pinning the problem type to a single image ID. The ID comes from the
+// digest parameter if present, or else from the daycares that are currently
+// registered, which must all agree.
+func PutProblemTypeImageDigest(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params martini.Params, render render.Render) {
+	name := params["name"]
+	if _, err := getProblemType(tx, name); err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+
+	digest := r.FormValue("digest")
+	if digest == "" {
+		var err error
+		if digest, err = daycareRegistrations.ImageDigest(name); err != nil {
+			loggedHTTPErrorf(w, http.StatusConflict, "unable to pin problem type %s: %v", name, err)
+			return
+		}
+	} else if !strings.HasPrefix(digest, "sha256:") {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "image ID must start with sha256:")
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE problem_types SET image_digest = ? WHERE name = ?`, digest, name); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("problem type %s pinned to image %s", name, digest)
+
+	problemType, err := getProblemType(tx, name)
+	if err != nil {
+		loggedHTTPDBNotFoundError(w, err)
+		return
+	}
+	render.JSON(http.StatusOK, problemType)
+}
+
+// DeleteProblemTypeImageDigest handles a request to /problem_types/:name/image_digest,
+// unpinning the problem type so any daycare with its image tag can be used.
+func DeleteProblemTypeImageDigest(w http.ResponseWriter, tx *sql.Tx, params martini.Params) {
+	name := params["name"]
+	if _, err := tx.Exec(`UPDATE problem_types SET image_digest = NULL WHERE name = ?`, name); err != nil {
+		loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+		return
+	}
+	log.Printf("problem type %s unpinned", name)
+}
+
 func getProblemType(tx *sql.Tx, name string) (*ProblemType, error) {
 	problemType := new(ProblemType)
 	err := meddler.QueryRow(tx, problemType, `SELECT * FROM problem_types WHERE name = ?`, name)
@@ -56,8 +123,9 @@ func getProblemType(tx *sql.Tx, name string) (*ProblemType, error) {
 		return nil, err
 	}
 
-	// gather files
+	// gather files, marking the executable and binary ones
 	problemType.Files = make(map[string][]byte)
+	problemType.FileMeta = make(map[string]FileMeta)
 	dir := filepath.Join(root, "files", name)
 	dirInfo, err := os.Lstat(dir)
 	if err == nil && dirInfo.IsDir() {
@@ -78,6 +146,9 @@ func getProblemType(tx *sql.Tx, name string) (*ProblemType, error) {
 				return err
 			}
 			problemType.Files[relpath] = raw
+			if meta := fileMetaFor(info, raw); meta != (FileMeta{}) {
+				problemType.FileMeta[relpath] = meta
+			}
 
 			return nil
 		})
@@ -97,9 +168,29 @@ func getProblemType(tx *sql.Tx, name string) (*ProblemType, error) {
 		problemType.Actions[elt.Action] = elt
 	}
 
+	// gather the stages of multi-stage actions
+	stages := []*ProblemTypeActionStage{}
+	err = meddler.QueryAll(tx, &stages, `SELECT * FROM problem_type_action_stages WHERE problem_type = ? ORDER BY action, sequence`, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, elt := range stages {
+		if action := problemType.Actions[elt.Action]; action != nil {
+			action.Stages = append(action.Stages, elt)
+		}
+	}
+
 	return problemType, nil
 }
 
+// fileMetaFor describes a file stored on the TA using its permission bits.
+func fileMetaFor(info os.FileInfo, contents []byte) FileMeta {
+	return FileMeta{
+		Executable: info.Mode()&0111 != 0,
+		Binary:     IsBinary(contents),
+	}
+}
+
 // GetProblems handles a request to /problems,
 // returning a list of all problems.
 //
@@ -220,6 +311,7 @@ func GetProblemSteps(w http.ResponseWriter, r *http.Request, tx *sql.Tx, params
 	if !currentUser.Admin && !currentUser.Author {
 		for _, elt := range problemSteps {
 			elt.Solution = nil
+			elt.SolutionMeta = nil
 		}
 	}
 
@@ -256,6 +348,7 @@ func GetProblemStep(w http.ResponseWriter, tx *sql.Tx, params martini.Params, cu
 
 	if !currentUser.Admin && !currentUser.Author {
 		problemStep.Solution = nil
+		problemStep.SolutionMeta = nil
 	}
 	render.JSON(http.StatusOK, problemStep)
 }
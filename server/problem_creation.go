@@ -178,6 +178,7 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, currentUser *Use
 
 		// keep a copy of the solution
 		steps[i].Solution = commit.Files
+		steps[i].SolutionMeta = commit.FileMeta
 	}
 
 	isUpdate, oldStepCount := false, 0
@@ -213,6 +214,11 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, currentUser *Use
 				loggedHTTPErrorf(w, http.StatusInternalServerError, "json encoding error for step.Files: %v", err)
 				return
 			}
+			fileMetaJSON, err := json.Marshal(step.FileMeta)
+			if err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "json encoding error for step.FileMeta: %v", err)
+				return
+			}
 			whitelistJSON, err := json.Marshal(step.Whitelist)
 			if err != nil {
 				loggedHTTPErrorf(w, http.StatusInternalServerError, "json encoding error for step.Whitelist: %v", err)
@@ -223,22 +229,31 @@ func saveProblemBundleCommon(w http.ResponseWriter, tx *sql.Tx, currentUser *Use
 				loggedHTTPErrorf(w, http.StatusInternalServerError, "json encoding error for step.Solution: %v", err)
 				return
 			}
+			solutionMetaJSON, err := json.Marshal(step.SolutionMeta)
+			if err != nil {
+				loggedHTTPErrorf(w, http.StatusInternalServerError, "json encoding error for step.SolutionMeta: %v", err)
+				return
+			}
 			result, err := tx.Exec(`UPDATE problem_steps SET `+
 				`problem_type=?, `+
 				`note=?, `+
 				`instructions=?, `+
 				`weight=?, `+
 				`files=?, `+
+				`file_meta=?, `+
 				`whitelist=?, `+
-				`solution=? `+
+				`solution=?, `+
+				`solution_meta=? `+
 				`WHERE problem_id=? AND step=?`,
 				step.ProblemType,
 				step.Note,
 				step.Instructions,
 				step.Weight,
 				filesJSON,
+				fileMetaJSON,
 				whitelistJSON,
 				solutionJSON,
+				solutionMetaJSON,
 				step.ProblemID,
 				step.Step)
 			if err != nil {
@@ -345,6 +360,14 @@ func PostProblemBundleUnconfirmed(w http.ResponseWriter, tx *sql.Tx, currentUser
 		loggedHTTPErrorf(w, http.StatusBadRequest, "%v", err)
 		return
 	}
+	for _, action := range OptionActions(bundle.Problem.Options) {
+		for name, problemType := range bundle.ProblemTypes {
+			if _, exists := problemType.Actions[action]; !exists {
+				loggedHTTPErrorf(w, http.StatusBadRequest, "an option applies to the %s action, but problem type %s has no such action", action, name)
+				return
+			}
+		}
+	}
 
 	// if this is an update to an existing problem, we need to check that some things match
 	if bundle.Problem.ID != 0 {
@@ -390,7 +413,7 @@ func PostProblemBundleUnconfirmed(w http.ResponseWriter, tx *sql.Tx, currentUser
 	bundle.ProblemSignature = bundle.Problem.ComputeSignature(Config.DaycareSecret, bundle.ProblemSteps)
 
 	// assign a daycare host
-	host, err := daycareRegistrations.Assign(typeSet)
+	host, err := daycareRegistrations.Assign(bundle.ProblemTypes, currentUser.ID)
 	if err != nil {
 		names := ""
 		for name := range typeSet {
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// queueUpdateInterval is how often a waiting client is sent its position
+// even if it has not changed, so it knows the daycare is still alive.
+const queueUpdateInterval = 15 * time.Second
+
+// initialRunEstimate is the assumed time per run before any have finished.
+const initialRunEstimate = 30 * time.Second
+
+var errQueueFull = errors.New("the daycare is busy and its queue is full, please try again in a few minutes")
+
+// containerQueue limits the number of concurrent containers. Requests that
+// arrive when every slot is busy wait in line and are admitted in the order
+// they arrived.
+type containerQueue struct {
+	sync.Mutex
+	capacity  int
+	maxLength int
+	active    int
+	waiting   []*queueTicket
+	average   time.Duration
+}
+
+// queueTicket is a place in line.
+type queueTicket struct {
+	// ready is closed when the ticket has been granted a slot
+	ready chan struct{}
+
+	// moved receives a value when the ticket moves up in line
+	moved chan struct{}
+}
+
+var containerLimiter *containerQueue
+
+func newContainerQueue(capacity, maxLength int) *containerQueue {
+	return &containerQueue{
+		capacity:  capacity,
+		maxLength: maxLength,
+		average:   initialRunEstimate,
+	}
+}
+
+// Enqueue gets in line for a slot. If one is free and nobody is waiting
+// the ticket is ready immediately. It returns errQueueFull if the line is
+// already at its maximum length.
+func (q *containerQueue) Enqueue() (*queueTicket, error) {
+	q.Lock()
+	defer q.Unlock()
+
+	t := &queueTicket{
+		ready: make(chan struct{}),
+		moved: make(chan struct{}, 1),
+	}
+	if q.active < q.capacity && len(q.waiting) == 0 {
+		q.active++
+		close(t.ready)
+		return t, nil
+	}
+	if len(q.waiting) >= q.maxLength {
+		return nil, errQueueFull
+	}
+	q.waiting = append(q.waiting, t)
+	return t, nil
+}
+
+// Position gives the 1-based place in line for a ticket and an estimate of
+// how long it will wait. It returns zero if the ticket is not waiting.
+func (q *containerQueue) Position(t *queueTicket) (int, time.Duration) {
+	q.Lock()
+	defer q.Unlock()
+
+	for i, elt := range q.waiting {
+		if elt == t {
+			// the first capacity tickets wait about one run, the next ones two, etc.
+			rounds := i/q.capacity + 1
+			return i + 1, time.Duration(rounds) * q.average
+		}
+	}
+	return 0, 0
+}
+
+// Cancel takes a ticket out of line. If it had already been granted
+// a slot, the slot is passed on to the next ticket.
+func (q *containerQueue) Cancel(t *queueTicket) {
+	q.Lock()
+	defer q.Unlock()
+
+	for i, elt := range q.waiting {
+		if elt == t {
+			q.waiting = append(q.waiting[:i:i], q.waiting[i+1:]...)
+			q.notifyMoved(i)
+			return
+		}
+	}
+	q.next()
+}
+
+// Release gives up a slot that was held for the given duration
+// and admits the next ticket in line.
+func (q *containerQueue) Release(held time.Duration) {
+	q.Lock()
+	defer q.Unlock()
+
+	// exponentially weighted moving average of run times
+	q.average = (q.average*7 + held) / 8
+	q.next()
+}
+
+// next hands a newly freed slot to the first ticket in line.
+// The lock must be held.
+func (q *containerQueue) next() {
+	if len(q.waiting) == 0 {
+		q.active--
+		return
+	}
+	t := q.waiting[0]
+	q.waiting = q.waiting[1:]
+	close(t.ready)
+	q.notifyMoved(0)
+}
+
+// Active reports the number of slots in use.
+func (q *containerQueue) Active() int {
+	q.Lock()
+	defer q.Unlock()
+	return q.active
+}
+
+// Depth reports the number of tickets waiting in line.
+func (q *containerQueue) Depth() int {
+	q.Lock()
+	defer q.Unlock()
+	return len(q.waiting)
+}
+
+// notifyMoved tells every ticket from index start onward that it moved up.
+// The lock must be held.
+func (q *containerQueue) notifyMoved(start int) {
+	for _, elt := range q.waiting[start:] {
+		select {
+		case elt.moved <- struct{}{}:
+		default:
+			// a notification is already pending
+		}
+	}
+}
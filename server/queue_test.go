@@ -0,0 +1,227 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	. "github.com/russross/codegrinder/types"
+)
+
+// isReady reports whether a ticket has been granted a slot.
+func isReady(ticket *queueTicket) bool {
+	select {
+	case <-ticket.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// hasMoved reports whether a ticket has been told it moved up in line,
+// clearing the notification.
+func hasMoved(ticket *queueTicket) bool {
+	select {
+	case <-ticket.moved:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestQueueOrder(t *testing.T) {
+	q := newContainerQueue(2, 3)
+	var tickets []*queueTicket
+	for i := 0; i < 5; i++ {
+		ticket, err := q.Enqueue()
+		if err != nil {
+			t.Fatalf("Enqueue %d: %v", i, err)
+		}
+		tickets = append(tickets, ticket)
+	}
+	if _, err := q.Enqueue(); err != errQueueFull {
+		t.Errorf("Enqueue with a full line returned %v, want errQueueFull", err)
+	}
+
+	// the first two get slots and the rest wait in the order they arrived
+	for i, ticket := range tickets {
+		position, wait := q.Position(ticket)
+		wantPosition, wantWait := 0, time.Duration(0)
+		if i >= 2 {
+			wantPosition = i - 1
+			wantWait = time.Duration((i-2)/2+1) * initialRunEstimate
+		}
+		if isReady(ticket) != (i < 2) || position != wantPosition || wait != wantWait {
+			t.Errorf("ticket %d: ready %v, position %d, wait %v; want ready %v, position %d, wait %v",
+				i, isReady(ticket), position, wait, i < 2, wantPosition, wantWait)
+		}
+	}
+
+	// each release admits the next ticket in line and moves the rest up
+	for i := 2; i < 5; i++ {
+		q.Release(initialRunEstimate)
+		if !isReady(tickets[i]) {
+			t.Errorf("ticket %d not admitted by release %d", i, i-1)
+		}
+		for j := i + 1; j < 5; j++ {
+			if isReady(tickets[j]) {
+				t.Errorf("ticket %d admitted ahead of ticket %d", j, i)
+			}
+			if !hasMoved(tickets[j]) {
+				t.Errorf("ticket %d not told it moved up", j)
+			}
+			if position, _ := q.Position(tickets[j]); position != j-i {
+				t.Errorf("ticket %d at position %d, want %d", j, position, j-i)
+			}
+		}
+	}
+	if q.Active() != 2 || q.Depth() != 0 {
+		t.Errorf("%d active and %d waiting, want 2 and 0", q.Active(), q.Depth())
+	}
+}
+
+func TestQueueCancel(t *testing.T) {
+	q := newContainerQueue(1, 5)
+	first, _ := q.Enqueue()
+	second, _ := q.Enqueue()
+	third, _ := q.Enqueue()
+	fourth, _ := q.Enqueue()
+
+	// a waiting ticket leaves the line and those behind it move up
+	q.Cancel(second)
+	if position, _ := q.Position(third); position != 1 || !hasMoved(third) {
+		t.Errorf("third ticket at position %d after the one ahead left, want 1", position)
+	}
+	if isReady(third) {
+		t.Errorf("third ticket admitted while the slot was held")
+	}
+
+	// a ticket that was granted a slot hands it to the next in line
+	q.Cancel(first)
+	if !isReady(third) {
+		t.Errorf("third ticket not admitted after the holder cancelled")
+	}
+	if isReady(fourth) {
+		t.Errorf("fourth ticket admitted with only one slot")
+	}
+	q.Cancel(third)
+	if !isReady(fourth) {
+		t.Errorf("fourth ticket not admitted after the third cancelled")
+	}
+
+	// the last slot is given back when nobody is waiting
+	q.Cancel(fourth)
+	if q.Active() != 0 || q.Depth() != 0 {
+		t.Errorf("%d active and %d waiting, want 0 and 0", q.Active(), q.Depth())
+	}
+}
+
+// waitInQueueOverSocket runs waitInQueue for a ticket with a client on the
+// other end of a websocket. It returns the client's end and a channel that
+// receives waitInQueue's result.
+func waitInQueueOverSocket(t *testing.T, ticket *queueTicket, run *userRun) (*websocket.Conn, chan bool) {
+	t.Helper()
+	result := make(chan bool, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		socket, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrading to a websocket: %v", err)
+			return
+		}
+		defer socket.Close()
+		result <- waitInQueue(socket, ticket, run)
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("dialing the websocket: %v", err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, result
+}
+
+// readEvent reads the next event sent to a websocket client.
+func readEvent(t *testing.T, client *websocket.Conn) *EventMessage {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	res := new(DaycareResponse)
+	if err := client.ReadJSON(res); err != nil {
+		t.Fatalf("reading from the websocket: %v", err)
+	}
+	if res.Event == nil {
+		t.Fatalf("expected an event, got %+v", res)
+	}
+	return res.Event
+}
+
+func TestWaitInQueue(t *testing.T) {
+	saved := containerLimiter
+	defer func() { containerLimiter = saved }()
+	containerLimiter = newContainerQueue(1, 5)
+
+	holder, _ := containerLimiter.Enqueue()
+	ahead, _ := containerLimiter.Enqueue()
+	ticket, _ := containerLimiter.Enqueue()
+	run := &userRun{cancel: make(chan struct{}), done: make(chan struct{})}
+	client, result := waitInQueueOverSocket(t, ticket, run)
+
+	// the client hears its place in line each time it moves up
+	for _, want := range []int{2, 1} {
+		event := readEvent(t, client)
+		if event.Event != "queued" || event.QueuePosition != want || event.QueueWait != time.Duration(want)*initialRunEstimate {
+			t.Errorf("got %s event at position %d with wait %v, want queued at %d",
+				event.Event, event.QueuePosition, event.QueueWait, want)
+		}
+		if want == 2 {
+			containerLimiter.Cancel(ahead)
+		}
+	}
+
+	containerLimiter.Cancel(holder)
+	select {
+	case ok := <-result:
+		if !ok {
+			t.Errorf("waitInQueue gave up instead of taking the slot")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("waitInQueue did not return after the slot was freed")
+	}
+	containerLimiter.Release(initialRunEstimate)
+}
+
+func TestWaitInQueueCancelled(t *testing.T) {
+	saved := containerLimiter
+	defer func() { containerLimiter = saved }()
+	containerLimiter = newContainerQueue(1, 5)
+
+	holder, _ := containerLimiter.Enqueue()
+	ticket, _ := containerLimiter.Enqueue()
+	run := &userRun{cancel: make(chan struct{}), done: make(chan struct{})}
+	client, result := waitInQueueOverSocket(t, ticket, run)
+
+	if event := readEvent(t, client); event.Event != "queued" || event.QueuePosition != 1 {
+		t.Errorf("got %s event at position %d, want queued at 1", event.Event, event.QueuePosition)
+	}
+
+	// a newer run by the same student takes this one out of line
+	run.stop()
+	if event := readEvent(t, client); event.Event != "error" || event.Error != errCancelled.Error() {
+		t.Errorf("got %s event %q, want the cancellation error", event.Event, event.Error)
+	}
+	if ok := <-result; ok {
+		t.Errorf("waitInQueue took a slot for a cancelled run")
+	}
+	if depth := containerLimiter.Depth(); depth != 0 {
+		t.Errorf("%d tickets waiting after the cancelled run left, want 0", depth)
+	}
+
+	// so the slot goes back to the pool when the holder finishes
+	containerLimiter.Cancel(holder)
+	if active := containerLimiter.Active(); active != 0 {
+		t.Errorf("%d slots active, want 0", active)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+// containerLabel marks every container a daycare creates with the daycare's
+// host name so leftovers can be found after a crash.
+const containerLabel = "codegrinder.daycare"
+
+// reapInterval is how often the daycare looks for orphaned containers.
+const reapInterval = 5 * time.Minute
+
+// reapGracePeriod protects containers that were just created
+// and may not have been recorded yet.
+const reapGracePeriod = time.Minute
+
+// trackedEngine wraps a container engine and records which containers
+// this process created and has not yet removed.
+type trackedEngine struct {
+	ContainerEngine
+	sync.Mutex
+	live map[string]bool
+}
+
+func newTrackedEngine(engine ContainerEngine) *trackedEngine {
+	return &trackedEngine{
+		ContainerEngine: engine,
+		live:            make(map[string]bool),
+	}
+}
+
+func (e *trackedEngine) Create(spec *ContainerSpec) (string, error) {
+	id, err := e.ContainerEngine.Create(spec)
+	if err == nil {
+		e.Lock()
+		e.live[id] = true
+		e.Unlock()
+	}
+	return id, err
+}
+
+func (e *trackedEngine) Remove(id string) error {
+	err := e.ContainerEngine.Remove(id)
+	e.Lock()
+	delete(e.live, id)
+	e.Unlock()
+	return err
+}
+
+func (e *trackedEngine) tracked(id string) bool {
+	e.Lock()
+	defer e.Unlock()
+	return e.live[id]
+}
+
+// reapContainers removes containers and networks labeled as belonging to
+// this daycare that are not in use. At startup nothing is in use, so
+// everything labeled for this daycare is removed.
+func (e *trackedEngine) reapContainers(all bool) {
+	list, err := e.List(containerLabel, Config.Hostname)
+	if err != nil {
+		log.Printf("error looking for orphaned containers: %v", err)
+		return
+	}
+	for _, elt := range list {
+		if !all && (e.tracked(elt.ID) || time.Since(elt.Created) < reapGracePeriod) {
+			continue
+		}
+		log.Printf("removing orphaned container %s (%s) created %v", elt.Name, elt.ID, elt.Created.Format(time.RFC3339))
+		if err := e.Remove(elt.ID); err != nil {
+			log.Printf("%v", err)
+		}
+	}
+
+	// networks are only pruned once no container is using them
+	olderThan := reapGracePeriod
+	if all {
+		olderThan = 0
+	}
+	if err := e.PruneNetworks(containerLabel, Config.Hostname, olderThan); err != nil {
+		log.Printf("%v", err)
+	}
+}
+
+// reaper periodically removes orphaned containers.
+func (e *trackedEngine) reaper() {
+	for range time.Tick(reapInterval) {
+		e.reapContainers(false)
+	}
+}
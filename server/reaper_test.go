@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReapContainers(t *testing.T) {
+	hostname := Config.Hostname
+	defer func() { Config.Hostname = hostname }()
+	Config.Hostname = "daycare.example.com"
+
+	// containers left over from an earlier run, just created, in use,
+	// or belonging to something else
+	setup := func() (*trackedEngine, map[string]string) {
+		fake := newFakeEngine()
+		engine := newTrackedEngine(fake)
+		ours := map[string]string{containerLabel: Config.Hostname}
+		theirs := map[string]string{containerLabel: "other.example.com"}
+		ids := make(map[string]string)
+		create := func(name string, e ContainerEngine, labels map[string]string, age time.Duration) {
+			id, err := e.Create(&ContainerSpec{Name: name, Labels: labels})
+			if err != nil {
+				t.Fatalf("creating %s: %v", name, err)
+			}
+			fake.containers[id].created = time.Now().Add(-age)
+			ids[name] = id
+		}
+		create("orphan", fake, ours, time.Hour)
+		create("new", fake, ours, 0)
+		create("live", engine, ours, time.Hour)
+		create("other daycare", fake, theirs, time.Hour)
+		create("unlabeled", fake, nil, time.Hour)
+		return engine, ids
+	}
+
+	tests := []struct {
+		all     bool
+		removed []string
+		kept    []string
+	}{
+		{
+			all:     false,
+			removed: []string{"orphan"},
+			kept:    []string{"new", "live", "other daycare", "unlabeled"},
+		},
+		{
+			all:     true,
+			removed: []string{"orphan", "new", "live"},
+			kept:    []string{"other daycare", "unlabeled"},
+		},
+	}
+	for _, test := range tests {
+		engine, ids := setup()
+		engine.reapContainers(test.all)
+		for _, name := range test.removed {
+			if _, err := engine.State(ids[name]); err == nil {
+				t.Errorf("all=%v: container %s was not removed", test.all, name)
+			}
+		}
+		for _, name := range test.kept {
+			if _, err := engine.State(ids[name]); err != nil {
+				t.Errorf("all=%v: container %s was removed", test.all, name)
+			}
+		}
+	}
+}
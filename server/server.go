@@ -63,6 +63,17 @@ var Config struct {
 	AcmeCache       string      `json:"acmeDir"`         // Full path of Acme cache file: default "$CODEGRINDERROOT/acme"
 	SQLite3Path     string      `json:"sqlite3Path"`     // path to the sqlite database file: default "$CODEGRINDERROOT/db/codegrinder.db"
 	SessionsExpire  []time.Time `json:"sessionsExpire"`  // times/dates when sessions should expire (year is ignored)
+	DaycareStrategy string      `json:"daycareStrategy"` // How to pick a daycare: "least-loaded" (default), "random" (weighted by capacity), or "sticky" (same daycare per user while it has no queue)
+
+	// daycare-only parameters where the default is usually sufficient
+	ContainerEngine string            `json:"containerEngine"` // Container engine to run student code: "docker" (default, uses the Engine API), "docker-cli", "podman", or "fake" (no containers, for testing)
+	DockerSocket    string            `json:"dockerSocket"`    // Path to the Docker Engine API socket: default "/var/run/docker.sock"
+	WarmContainers  int               `json:"warmContainers"`  // Pre-started containers to keep per problem type, within capacity: default 0 (disabled)
+	MaxQueueLength  int               `json:"maxQueueLength"`  // Requests that can wait for a container before new ones are turned away: default 10 × capacity
+	ConcurrentRuns  string            `json:"concurrentRuns"`  // When a student starts a run while another is active: "cancel" (default, stop the earlier run), "queue" (wait for it), or "reject"
+	NetworkSidecars map[string]string `json:"networkSidecars"` // Sidecar images problems may request with the network=sidecar:<name> option, keyed by name: default none
+	Runtimes        map[string]string `json:"runtimes"`        // Engine runtime names for the runtimes problem types may request: default runc, runsc, and kata map to themselves
+	CanaryInterval  int               `json:"canaryInterval"`  // Minutes between runs of each problem type's canary; failing canaries are retried after 5 minutes: default 60
 }
 var root string
 
@@ -173,9 +184,6 @@ func main() {
 		// initialize random number generator
 		rand.Seed(time.Now().UnixNano())
 
-		// init the container limiter channel
-		containerLimiter = make(chan struct{}, Config.Capacity)
-
 		// make sure relevant fields included in config file
 		if Config.TAHostname == "" {
 			Config.TAHostname = Config.Hostname
@@ -186,8 +194,75 @@ func main() {
 		if Config.Capacity <= 0 {
 			log.Fatalf("Daycare capacity must be greater than zero")
 		}
+		switch Config.ConcurrentRuns {
+		case "":
+			Config.ConcurrentRuns = "cancel"
+		case "cancel", "queue", "reject":
+		default:
+			log.Fatalf("unknown concurrentRuns %q in the config file", Config.ConcurrentRuns)
+		}
+		if Config.MaxQueueLength <= 0 {
+			Config.MaxQueueLength = 10 * Config.Capacity
+		}
+		if Config.Runtimes == nil {
+			Config.Runtimes = make(map[string]string)
+			for _, name := range ContainerRuntimes {
+				Config.Runtimes[name] = name
+			}
+		}
+		for name := range Config.Runtimes {
+			known := false
+			for _, elt := range ContainerRuntimes {
+				known = known || elt == name
+			}
+			if !known {
+				log.Fatalf("unknown runtime %q in the config file, must be one of %s", name, strings.Join(ContainerRuntimes, ", "))
+			}
+		}
+
+		// init the container limiter queue
+		containerLimiter = newContainerQueue(Config.Capacity, Config.MaxQueueLength)
+		engine, err := newContainerEngine(Config.ContainerEngine, Config.DockerSocket)
+		if err != nil {
+			log.Fatalf("cannot run Daycare role: %v", err)
+		}
+		tracked := newTrackedEngine(engine)
+		containerEngine = tracked
+
+		// clean up after an earlier daycare process that did not exit cleanly
+		tracked.reapContainers(true)
+		go tracked.reaper()
+
+		if Config.WarmContainers > 0 {
+			warmPool = newContainerPool(tracked, Config.WarmContainers, Config.Capacity, Config.ProblemTypes)
+			go warmPool.sweep()
+			go func() {
+				if ta {
+					// give the TA a chance to start listening
+					time.Sleep(2 * time.Second)
+				}
+				warmPool.warmup()
+			}()
+		}
 
 		r.Get("/sockets/:problem_type/:action", SocketProblemTypeAction)
+		r.Get("/canaries", GetCanaries)
+
+		// finish running sessions before exiting on SIGTERM
+		go drainOnSignal()
+
+		// run canaries at startup and periodically
+		canaryInterval := defaultCanaryInterval
+		if Config.CanaryInterval > 0 {
+			canaryInterval = time.Duration(Config.CanaryInterval) * time.Minute
+		}
+		go func() {
+			if ta {
+				// give the TA a chance to start listening
+				time.Sleep(2 * time.Second)
+			}
+			canaryRunner(canaryInterval)
+		}()
 
 		// register with the TA periodically
 		go func() {
@@ -199,11 +274,27 @@ func main() {
 			client := &http.Client{Timeout: time.Second * 5}
 
 			for {
+				// a draining daycare sends one last registration with no capacity
+				// so the TA stops sending work its way right away
+				capacity := drain.Capacity(Config.Capacity)
+
+				// only offer problem types whose image is present
+				// and whose canary has passed
 				start := time.Now()
+				var passing []string
+				for _, name := range Config.ProblemTypes {
+					if canaries.Passing(name) {
+						passing = append(passing, name)
+					}
+				}
+				present, digests := problemTypeImages.Present(containerEngine, passing)
 				reg := DaycareRegistration{
 					Hostname:     Config.Hostname,
-					ProblemTypes: Config.ProblemTypes,
-					Capacity:     Config.Capacity,
+					ProblemTypes: present,
+					ImageDigests: digests,
+					Capacity:     capacity,
+					Active:       containerLimiter.Active(),
+					Queued:       containerLimiter.Depth(),
 					Time:         time.Now(),
 					Version:      CurrentVersion.Version,
 				}
@@ -239,6 +330,15 @@ func main() {
 							log.Printf("attempt took %v", time.Since(start))
 						}
 						status = "succeeded"
+
+						// learn which images the problem types use, and
+						// register again right away if the list changed
+						reply := new(DaycareRegistrationReply)
+						if err := json.Unmarshal(body, reply); err != nil {
+							log.Printf("error decoding daycare registration reply: %v", err)
+						} else if problemTypeImages.Update(reply.Images, reply.Digests) && capacity > 0 {
+							continue
+						}
 					} else {
 						if status != "failed" {
 							log.Printf("unexpected status from %s: %v", url, res.Status)
@@ -251,7 +351,14 @@ func main() {
 						status = "failed"
 					}
 				}
-				time.Sleep(daycareRegistrationInterval)
+				if capacity == 0 {
+					log.Printf("stopped registering with the TA")
+					return
+				}
+				select {
+				case <-time.After(daycareRegistrationInterval):
+				case <-drain.Draining():
+				}
 			}
 		}()
 	}
@@ -268,6 +375,11 @@ func main() {
 		if Config.SQLite3Path == "" {
 			log.Fatalf("cannot run TA role with no sqlite3Path in the config file")
 		}
+		switch Config.DaycareStrategy {
+		case "", "least-loaded", "random", "sticky":
+		default:
+			log.Fatalf("unknown daycareStrategy %q in the config file", Config.DaycareStrategy)
+		}
 
 		// skipMiddleware wraps a martini.Handler, skipping it if the request path
 		// starts with the given prefix.
@@ -427,13 +539,33 @@ func main() {
 				daycareRegistrations.Expire()
 				render.JSON(http.StatusOK, daycareRegistrations.daycares)
 			})
-		r.Post("/daycare_registrations", gunzip, binding.Json(DaycareRegistration{}),
-			func(w http.ResponseWriter, reg DaycareRegistration) {
+		r.Get("/daycare_canaries/:problem_type", withTx, GetDaycareCanary)
+		r.Get("/daycare_problem_types/:problem_type", withTx, GetDaycareProblemType)
+		r.Post("/daycare_registrations", gunzip, binding.Json(DaycareRegistration{}), withTx,
+			func(w http.ResponseWriter, tx *sql.Tx, reg DaycareRegistration, render render.Render) {
 				daycareRegistrations.Expire()
 				if err := daycareRegistrations.Insert(&reg); err != nil {
 					loggedHTTPErrorf(w, http.StatusBadRequest, "bad daycare registration: %v", err)
 					return
 				}
+
+				// tell the daycare which images to look for
+				problemTypes := []*ProblemType{}
+				if err := meddler.QueryAll(tx, &problemTypes, `SELECT * FROM problem_types`); err != nil {
+					loggedHTTPErrorf(w, http.StatusInternalServerError, "db error: %v", err)
+					return
+				}
+				reply := &DaycareRegistrationReply{
+					Images:  make(map[string]string),
+					Digests: make(map[string]string),
+				}
+				for _, elt := range problemTypes {
+					reply.Images[elt.Name] = elt.Image
+					if elt.ImageDigest != "" {
+						reply.Digests[elt.Name] = elt.ImageDigest
+					}
+				}
+				render.JSON(http.StatusOK, reply)
 			})
 
 		// stats
@@ -468,6 +600,8 @@ func main() {
 		// problem types
 		r.Get("/problem_types", counter, auth, withTx, GetProblemTypes)
 		r.Get("/problem_types/:name", counter, auth, withTx, GetProblemType)
+		r.Put("/problem_types/:name/image_digest", counter, withTx, withCurrentUser, administratorOnly, PutProblemTypeImageDigest)
+		r.Delete("/problem_types/:name/image_digest", counter, withTx, withCurrentUser, administratorOnly, DeleteProblemTypeImageDigest)
 
 		// problems
 		r.Get("/problems", counter, withTx, withCurrentUser, GetProblems)
@@ -665,12 +799,14 @@ func unBase64(s string) string {
 type daycares struct {
 	sync.Mutex
 	daycares map[string]*DaycareRegistration
+	sticky   map[int64]string
 }
 
 var daycareRegistrations daycares
 
 func init() {
 	daycareRegistrations.daycares = make(map[string]*DaycareRegistration)
+	daycareRegistrations.sticky = make(map[int64]string)
 }
 
 func (m *daycares) Expire() {
@@ -681,6 +817,11 @@ func (m *daycares) Expire() {
 		if time.Since(elt.Time) > 2*daycareRegistrationInterval {
 			log.Printf("daycare registration for %s has expired", host)
 			delete(m.daycares, host)
+			for userID, sticky := range m.sticky {
+				if sticky == host {
+					delete(m.sticky, userID)
+				}
+			}
 		}
 	}
 }
@@ -718,59 +859,149 @@ func (m *daycares) Insert(reg *DaycareRegistration) error {
 	return nil
 }
 
-func (m *daycares) Assign(problemTypes map[string]bool) (string, error) {
+// ImageDigest returns the image ID that every registered daycare supporting
+// the given problem type reports for it. It is an error if none do or if
+// they disagree.
+func (m *daycares) ImageDigest(problemType string) (string, error) {
 	m.Lock()
 	defer m.Unlock()
 
-	// gather the total weights of all of the eligible daycare hosts
-	totalWeight := 0
+	digest := ""
+	for host, elt := range m.daycares {
+		found := elt.ImageDigests[problemType]
+		if found == "" {
+			continue
+		}
+		if digest != "" && found != digest {
+			return "", fmt.Errorf("daycares disagree on the image: %s reports %s but another reports %s", host, found, digest)
+		}
+		digest = found
+	}
+	if digest == "" {
+		return "", fmt.Errorf("no daycare reports an image for %s", problemType)
+	}
+	return digest, nil
+}
+
+// Assign picks a daycare host that supports all of the given problem types
+// for a request on behalf of the given user, using the strategy from the
+// config file. Problem types pinned to an image ID only match daycares
+// whose local image has that ID.
+func (m *daycares) Assign(problemTypes map[string]*ProblemType, userID int64) (string, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	// gather the eligible daycare hosts
+	var eligible []*DaycareRegistration
 	for _, elt := range m.daycares {
 		// does this daycare support all required problem types?
 		supported := true
-		for problemType := range problemTypes {
-			n := sort.SearchStrings(elt.ProblemTypes, problemType)
-			if n >= len(elt.ProblemTypes) || elt.ProblemTypes[n] != problemType {
+		for name, problemType := range problemTypes {
+			n := sort.SearchStrings(elt.ProblemTypes, name)
+			if n >= len(elt.ProblemTypes) || elt.ProblemTypes[n] != name {
+				supported = false
+				break
+			}
+			if problemType.ImageDigest != "" && elt.ImageDigests[name] != problemType.ImageDigest {
 				supported = false
 				break
 			}
 		}
-		if supported {
-			totalWeight += elt.Capacity
+		if supported && elt.Capacity > 0 {
+			eligible = append(eligible, elt)
 		}
 	}
-	if totalWeight == 0 {
+	if len(eligible) == 0 {
 		return "", fmt.Errorf("no eligible daycare found")
 	}
+	sort.Slice(eligible, func(i, j int) bool { return eligible[i].Hostname < eligible[j].Hostname })
+
+	var pick *DaycareRegistration
+	switch Config.DaycareStrategy {
+	case "random":
+		pick = pickRandomWeighted(eligible)
+	case "sticky":
+		if host := m.sticky[userID]; host != "" {
+			for _, elt := range eligible {
+				if elt.Hostname == host && elt.Queued == 0 {
+					pick = elt
+					break
+				}
+			}
+		}
+		if pick == nil {
+			pick = pickLeastLoaded(eligible)
+		}
+		m.sticky[userID] = pick.Hostname
+	default:
+		pick = pickLeastLoaded(eligible)
+	}
+
+	// count this request against the host until its next heartbeat
+	// so a burst of requests is not all sent to the same place
+	pick.Active++
+	return pick.Hostname, nil
+}
+
+// load is the fraction of a daycare's capacity that is in use or queued.
+func (reg *DaycareRegistration) load() float64 {
+	return float64(reg.Active+reg.Queued) / float64(reg.Capacity)
+}
+
+// pickLeastLoaded returns the daycare with the lowest load,
+// breaking ties randomly.
+func pickLeastLoaded(eligible []*DaycareRegistration) *DaycareRegistration {
+	var best []*DaycareRegistration
+	for _, elt := range eligible {
+		switch {
+		case len(best) == 0 || elt.load() < best[0].load():
+			best = []*DaycareRegistration{elt}
+		case elt.load() == best[0].load():
+			best = append(best, elt)
+		}
+	}
+	return best[rand.Intn(len(best))]
+}
+
+// pickRandomWeighted returns a random daycare weighted by capacity.
+// If any of them has nobody waiting in its queue, the busy ones are skipped.
+func pickRandomWeighted(eligible []*DaycareRegistration) *DaycareRegistration {
+	var idle []*DaycareRegistration
+	for _, elt := range eligible {
+		if elt.Queued == 0 {
+			idle = append(idle, elt)
+		}
+	}
+	if len(idle) > 0 {
+		eligible = idle
+	}
 
 	// pick a random point in pool of weights
+	totalWeight := 0
+	for _, elt := range eligible {
+		totalWeight += elt.Capacity
+	}
 	point := rand.Intn(totalWeight)
 	skippedWeight := 0
-	for host, elt := range m.daycares {
-		supported := true
-		for problemType := range problemTypes {
-			n := sort.SearchStrings(elt.ProblemTypes, problemType)
-			if n >= len(elt.ProblemTypes) || elt.ProblemTypes[n] != problemType {
-				supported = false
-				break
-			}
-		}
-		if supported {
-			skippedWeight += elt.Capacity
-		}
+	for _, elt := range eligible {
+		skippedWeight += elt.Capacity
 		if point < skippedWeight {
-			return host, nil
+			return elt
 		}
 	}
-	return "", fmt.Errorf("failed to find daycare, please report this error")
+	return eligible[len(eligible)-1]
 }
 
 type DaycareRegistration struct {
-	Hostname     string    `json:"hostname"`
-	ProblemTypes []string  `json:"problemTypes"`
-	Capacity     int       `json:"capacity"`
-	Time         time.Time `json:"time"`
-	Version      string    `json:"version,omitempty"`
-	Signature    string    `json:"signature,omitempty"`
+	Hostname     string            `json:"hostname"`
+	ProblemTypes []string          `json:"problemTypes"`
+	ImageDigests map[string]string `json:"imageDigests"` // problem type name to the ID of its image on the daycare
+	Capacity     int               `json:"capacity"`
+	Active       int               `json:"active"`
+	Queued       int               `json:"queued"`
+	Time         time.Time         `json:"time"`
+	Version      string            `json:"version,omitempty"`
+	Signature    string            `json:"signature,omitempty"`
 }
 
 func (reg *DaycareRegistration) ComputeSignature(secret string) string {
@@ -781,8 +1012,11 @@ func (reg *DaycareRegistration) ComputeSignature(secret string) string {
 	sort.Strings(reg.ProblemTypes)
 	for n, elt := range reg.ProblemTypes {
 		v.Add(fmt.Sprintf("problemType-%d", n), elt)
+		v.Add(fmt.Sprintf("imageDigest-%d", n), reg.ImageDigests[elt])
 	}
 	v.Add("capacity", strconv.Itoa(reg.Capacity))
+	v.Add("active", strconv.Itoa(reg.Active))
+	v.Add("queued", strconv.Itoa(reg.Queued))
 	v.Add("time", reg.Time.Round(time.Second).UTC().Format(time.RFC3339))
 	v.Add("version", reg.Version)
 
@@ -794,6 +1028,62 @@ func (reg *DaycareRegistration) ComputeSignature(secret string) string {
 	return sig
 }
 
+// daycareRequestSignature signs a daycare's request to the TA on behalf of
+// a problem type.
+func daycareRequestSignature(secret, hostname, problemType string, when time.Time) string {
+	v := make(url.Values)
+	v.Add("hostname", hostname)
+	v.Add("problemType", problemType)
+	v.Add("time", when.Round(time.Second).UTC().Format(time.RFC3339))
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(encode(v))
+	sum := mac.Sum(nil)
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+// checkDaycareRequest checks the signature on a request from a daycare,
+// reporting an error and returning false if it is not valid.
+func checkDaycareRequest(w http.ResponseWriter, r *http.Request, name string) bool {
+	when, err := time.Parse(time.RFC3339, r.FormValue("time"))
+	if err != nil {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "error parsing time in daycare request: %v", err)
+		return false
+	}
+	drift := time.Since(when)
+	if drift < 0 {
+		drift = -drift
+	}
+	if drift > time.Minute {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "time drift is too great")
+		return false
+	}
+	sig := daycareRequestSignature(Config.DaycareSecret, r.FormValue("hostname"), name, when)
+	if !hmac.Equal([]byte(sig), []byte(r.FormValue("signature"))) {
+		loggedHTTPErrorf(w, http.StatusBadRequest, "signature mismatch in daycare request")
+		return false
+	}
+	return true
+}
+
+// daycareGet makes a signed request from this daycare to the TA.
+func daycareGet(path, problemType string) (*http.Response, error) {
+	now := time.Now()
+	query := url.Values{
+		"hostname":  {Config.Hostname},
+		"time":      {now.Round(time.Second).UTC().Format(time.RFC3339)},
+		"signature": {daycareRequestSignature(Config.DaycareSecret, Config.Hostname, problemType, now)},
+	}
+	endpoint := &url.URL{
+		Scheme:   "https",
+		Host:     Config.TAHostname,
+		Path:     path,
+		RawQuery: query.Encode(),
+	}
+	client := &http.Client{Timeout: time.Minute}
+	return client.Get(endpoint.String())
+}
+
 var (
 	hits                  int
 	hitsCounter           = expvar.NewInt("hits")
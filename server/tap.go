@@ -0,0 +1,351 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	registerParser("tap", runAndParseTAP)
+}
+
+// runAndParseTAP runs a test command that writes TAP (the Test Anything
+// Protocol) to stdout and records each test point in the report card.
+func runAndParseTAP(n *Nanny, cmd []string) {
+	stdout, _, _, status, err := n.Exec(cmd)
+	if err != nil {
+		n.ReportCard.LogAndFailf("Error running unit tests: %v", err)
+		return
+	}
+
+	// did it end in a segfault?
+	if status > 127 {
+		n.ReportCard.LogAndFailf("Crashed with exit status %d while running unit tests", status)
+		return
+	}
+	n.ReportCard.Passed = status == 0
+
+	parseTAP(n, stdout.String())
+}
+
+var tapTestPoint = regexp.MustCompile(`^(not ok|ok)\b\s*(\d+)?\s*(?:-\s*)?(.*)$`)
+var tapPlan = regexp.MustCompile(`^1\.\.(\d+)\s*(?:#\s*(.*))?$`)
+
+// tapResult is a test point waiting to be added to the report card.
+type tapResult struct {
+	name    string
+	outcome string
+	details string
+}
+
+// tapBlock is one level of TAP output: the top level or a subtest, which
+// is indented below its parent. A subtest's results are kept until the
+// parent's test point that sums it up gives them a name.
+type tapBlock struct {
+	indent  int
+	name    string
+	results []*tapResult
+	last    *tapResult
+	count   int
+	planned int
+	skipAll string
+
+	// the next subtest, named by a "# Subtest:" comment at this level
+	subtest string
+
+	// a YAML diagnostic block below the last test point
+	inYAML     bool
+	yamlIndent int
+	yaml       []string
+}
+
+func newTAPBlock(indent int, name string) *tapBlock {
+	return &tapBlock{indent: indent, name: name, planned: -1}
+}
+
+// close checks a finished subtest against its plan.
+func (b *tapBlock) close() {
+	if b.planned >= 0 && b.count != b.planned {
+		b.results = append(b.results, &tapResult{
+			name:    "plan",
+			outcome: "failed",
+			details: fmt.Sprintf("planned %d tests but ran %d", b.planned, b.count),
+		})
+	}
+}
+
+// adopt adds the results of a finished subtest under the given name.
+// It reports whether any of them failed.
+func (b *tapBlock) adopt(child *tapBlock, name string) bool {
+	failed := false
+	for _, elt := range child.results {
+		elt.name = name + " -> " + elt.name
+		failed = failed || elt.outcome == "failed"
+		b.results = append(b.results, elt)
+	}
+	return failed
+}
+
+// splitTAPDirective separates the description of a test point from a
+// trailing SKIP or TODO directive. A "#" escaped with a backslash is part
+// of the description.
+func splitTAPDirective(s string) (desc, directive, reason string) {
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if s[i] != '#' {
+			continue
+		}
+		desc = s[:i]
+		comment := strings.TrimSpace(s[i+1:])
+		word := comment
+		if space := strings.IndexAny(comment, " \t"); space >= 0 {
+			word = comment[:space]
+		}
+		switch upper := strings.ToUpper(word); {
+		case strings.HasPrefix(upper, "SKIP"):
+			directive = "SKIP"
+		case strings.HasPrefix(upper, "TODO"):
+			directive = "TODO"
+		}
+		if directive != "" {
+			reason = strings.TrimSpace(comment[len(word):])
+		}
+		s = desc
+		break
+	}
+	desc = strings.TrimSpace(strings.Replace(s, `\#`, "#", -1))
+	return desc, directive, reason
+}
+
+func parseTAP(n *Nanny, output string) {
+	stack := []*tapBlock{newTAPBlock(0, "")}
+	bailed := false
+
+	for _, raw := range strings.Split(output, "\n") {
+		line := strings.TrimRight(raw, " \t\r")
+		trimmed := strings.TrimLeft(line, " \t")
+		indent := len(line) - len(trimmed)
+		top := stack[len(stack)-1]
+
+		// YAML diagnostics run from "---" to "..." below a test point
+		if top.inYAML {
+			if trimmed == "..." {
+				top.inYAML = false
+				if top.last.details != "" {
+					top.last.details += "\n"
+				}
+				top.last.details += strings.Join(top.yaml, "\n")
+				continue
+			}
+			if indent > top.yamlIndent {
+				line = line[top.yamlIndent:]
+			} else {
+				line = trimmed
+			}
+			top.yaml = append(top.yaml, line)
+			continue
+		}
+		if trimmed == "" {
+			continue
+		}
+		if trimmed == "---" && top.last != nil && indent > top.indent {
+			top.inYAML, top.yamlIndent, top.yaml = true, indent, nil
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "Bail out!") {
+			reason := strings.TrimSpace(strings.TrimPrefix(trimmed, "Bail out!"))
+			if reason == "" {
+				reason = "no reason given"
+			}
+			n.ReportCard.Failf("Bailed out: %s", reason)
+			bailed = true
+			break
+		}
+
+		// deeper indentation starts a subtest
+		if indent > top.indent {
+			if strings.HasPrefix(trimmed, "#") {
+				comment := strings.TrimSpace(strings.TrimPrefix(trimmed, "#"))
+				if strings.HasPrefix(comment, "Subtest:") {
+					name := strings.TrimSpace(strings.TrimPrefix(comment, "Subtest:"))
+					stack = append(stack, newTAPBlock(indent, name))
+					top.subtest = ""
+					continue
+				}
+
+				// indented diagnostics usually explain the last failure
+				if top.last != nil && top.last.outcome == "failed" {
+					top.last.details = joinTAPLines(top.last.details, comment)
+				}
+				continue
+			}
+			if !tapTestPoint.MatchString(trimmed) && !tapPlan.MatchString(trimmed) {
+				continue
+			}
+			child := newTAPBlock(indent, top.subtest)
+			top.subtest = ""
+			stack = append(stack, child)
+			top = child
+		}
+
+		// shallower indentation ends subtests
+		var closed *tapBlock
+		for indent < top.indent && len(stack) > 1 {
+			stack = stack[:len(stack)-1]
+			if closed != nil {
+				// a subtest with no test point of its own to sum it up
+				top.adopt(closed, subtestName(closed))
+			}
+			top.close()
+			closed = top
+			top = stack[len(stack)-1]
+		}
+
+		if groups := tapTestPoint.FindStringSubmatch(trimmed); groups != nil {
+			top.count++
+			desc, directive, reason := splitTAPDirective(groups[3])
+			name := desc
+			if name == "" {
+				number := groups[2]
+				if number == "" {
+					number = strconv.Itoa(top.count)
+				}
+				name = "test " + number
+			}
+			result := &tapResult{name: name}
+			switch {
+			case directive == "SKIP":
+				result.outcome, result.details = "skipped", reason
+			case directive == "TODO" && groups[1] == "not ok":
+				result.outcome, result.details = "skipped", todoDetails(reason)
+			case directive == "TODO":
+				result.outcome, result.details = "passed", todoDetails(reason)
+			case groups[1] == "ok":
+				result.outcome = "passed"
+			default:
+				result.outcome = "failed"
+			}
+
+			// a test point after a subtest sums it up, so it only needs
+			// its own entry if the subtest results do not explain it
+			if closed != nil {
+				childFailed := top.adopt(closed, name)
+				if len(closed.results) == 0 || (result.outcome == "failed" && !childFailed) {
+					top.results = append(top.results, result)
+				}
+			} else {
+				top.results = append(top.results, result)
+			}
+			top.last = result
+			top.subtest = ""
+			continue
+		}
+		if closed != nil {
+			top.adopt(closed, subtestName(closed))
+		}
+
+		if groups := tapPlan.FindStringSubmatch(trimmed); groups != nil {
+			top.planned, _ = strconv.Atoi(groups[1])
+			if top.planned == 0 {
+				_, _, reason := splitTAPDirective("#" + groups[2])
+				if reason == "" {
+					reason = "no reason given"
+				}
+				top.skipAll = reason
+			}
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "#") {
+			comment := strings.TrimSpace(strings.TrimPrefix(trimmed, "#"))
+			if strings.HasPrefix(comment, "Subtest:") {
+				top.subtest = strings.TrimSpace(strings.TrimPrefix(comment, "Subtest:"))
+			} else if top.last != nil && top.last.outcome == "failed" {
+				top.last.details = joinTAPLines(top.last.details, comment)
+			}
+		}
+
+		// anything else, such as the version line or stray output, is ignored
+	}
+
+	// close any subtests left open at the end of the output
+	for len(stack) > 1 {
+		child := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		child.close()
+		stack[len(stack)-1].adopt(child, subtestName(child))
+	}
+	top := stack[0]
+
+	// form a report card
+	passed, failed, skipped := 0, 0, 0
+	for _, elt := range top.results {
+		switch elt.outcome {
+		case "passed":
+			passed++
+			n.ReportCard.AddPassedResult(elt.name, elt.details)
+		case "skipped":
+			skipped++
+			n.ReportCard.AddSkippedResult(elt.name, elt.details)
+		default:
+			failed++
+			n.ReportCard.AddFailedResult(elt.name, elt.details, "")
+		}
+	}
+
+	switch {
+	case bailed:
+		// already noted
+	case top.skipAll != "":
+		n.ReportCard.Note = fmt.Sprintf("All tests skipped: %s", top.skipAll)
+		return
+	case passed+failed+skipped == 0:
+		n.ReportCard.LogAndFailf("No unit test results found")
+		return
+	case top.planned < 0:
+		n.ReportCard.Failf("Test output did not include a plan")
+	case top.count != top.planned:
+		n.ReportCard.Failf("Planned %d tests but ran %d", top.planned, top.count)
+	}
+
+	note := fmt.Sprintf("Passed %d/%d tests in %v", passed, passed+failed, time.Since(n.Start))
+	if skipped > 0 {
+		note += fmt.Sprintf(", skipped %d", skipped)
+	}
+	if n.ReportCard.Note != "" {
+		note += ", " + n.ReportCard.Note
+	}
+	n.ReportCard.Note = note
+}
+
+func subtestName(b *tapBlock) string {
+	if b.name != "" {
+		return b.name
+	}
+	return "subtest"
+}
+
+func todoDetails(reason string) string {
+	if reason == "" {
+		return "TODO"
+	}
+	return "TODO: " + reason
+}
+
+func joinTAPLines(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	default:
+		return a + "\n" + b
+	}
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/russross/codegrinder/types"
+)
+
+// parserTest describes the report card a parser should produce.
+type parserTest struct {
+	name    string
+	input   string
+	passed  bool
+	results []string          // "outcome: name" for each result, in order
+	details map[string]string // details expected for some results, by name
+	note    string            // a fragment of the expected note
+}
+
+// newTestNanny returns a nanny with a fresh report card and no container,
+// which is all a parser needs.
+func newTestNanny() *Nanny {
+	return &Nanny{ReportCard: NewReportCard(), Start: time.Now()}
+}
+
+func (test *parserTest) check(t *testing.T, card *ReportCard) {
+	t.Helper()
+	var results []string
+	for _, elt := range card.Results {
+		results = append(results, elt.Outcome+": "+elt.Name)
+	}
+	if !reflect.DeepEqual(results, test.results) {
+		t.Errorf("results:\n  got  %q\n  want %q", results, test.results)
+	}
+	for name, want := range test.details {
+		found := false
+		for _, elt := range card.Results {
+			if elt.Name == name {
+				found = true
+				if elt.Details != want {
+					t.Errorf("details for %s:\n  got  %q\n  want %q", name, elt.Details, want)
+				}
+			}
+		}
+		if !found {
+			t.Errorf("no result named %s", name)
+		}
+	}
+	if card.Passed != test.passed {
+		t.Errorf("passed is %v, want %v (note: %s)", card.Passed, test.passed, card.Note)
+	}
+	if !strings.Contains(card.Note, test.note) {
+		t.Errorf("note %q does not contain %q", card.Note, test.note)
+	}
+}
+
+func TestParseTAP(t *testing.T) {
+	tests := []*parserTest{
+		{
+			name: "simple",
+			input: "TAP version 13\n" +
+				"1..3\n" +
+				"ok 1 - adds\n" +
+				"not ok 2 - subtracts\n" +
+				"# expected 1\n" +
+				"# got 2\n" +
+				"ok 3\n",
+			passed:  false,
+			results: []string{"passed: adds", "failed: subtracts", "passed: test 3"},
+			details: map[string]string{"subtracts": "expected 1\ngot 2"},
+			note:    "Passed 2/3 tests",
+		},
+		{
+			name: "nested subtests",
+			input: "TAP version 13\n" +
+				"# Subtest: math\n" +
+				"    # Subtest: division\n" +
+				"        1..2\n" +
+				"        ok 1 - exact\n" +
+				"        not ok 2 - by zero\n" +
+				"          ---\n" +
+				"          message: boom\n" +
+				"          ...\n" +
+				"    not ok 1 - division\n" +
+				"    ok 2 - adds\n" +
+				"    1..2\n" +
+				"not ok 1 - math\n" +
+				"# Subtest: strings\n" +
+				"    1..1\n" +
+				"    ok 1 - concat\n" +
+				"ok 2 - strings\n" +
+				"1..2\n",
+			passed: false,
+			results: []string{
+				"passed: math -> division -> exact",
+				"failed: math -> division -> by zero",
+				"passed: math -> adds",
+				"passed: strings -> concat",
+			},
+			details: map[string]string{"math -> division -> by zero": "message: boom"},
+			note:    "Passed 3/4 tests",
+		},
+		{
+			name: "failed parent with passing subtests",
+			input: "1..1\n" +
+				"# Subtest: cleanup\n" +
+				"    1..1\n" +
+				"    ok 1 - runs\n" +
+				"not ok 1 - cleanup\n",
+			passed:  false,
+			results: []string{"passed: cleanup -> runs", "failed: cleanup"},
+		},
+		{
+			name: "subtest short of its plan",
+			input: "1..1\n" +
+				"# Subtest: group\n" +
+				"    1..3\n" +
+				"    ok 1 - one\n" +
+				"    ok 2 - two\n" +
+				"not ok 1 - group\n",
+			passed:  false,
+			results: []string{"passed: group -> one", "passed: group -> two", "failed: group -> plan"},
+			details: map[string]string{"group -> plan": "planned 3 tests but ran 2"},
+		},
+		{
+			name: "skip and todo",
+			input: "1..5\n" +
+				"ok 1 - fast\n" +
+				"ok 2 - network # SKIP no network\n" +
+				"not ok 3 - parser # TODO not written yet\n" +
+				"ok 4 - cache # todo\n" +
+				"not ok 5 # skip\n",
+			passed: true,
+			results: []string{
+				"passed: fast",
+				"skipped: network",
+				"skipped: parser",
+				"passed: cache",
+				"skipped: test 5",
+			},
+			details: map[string]string{
+				"network": "no network",
+				"parser":  "TODO: not written yet",
+				"cache":   "TODO",
+				"test 5":  "",
+			},
+			note: "Passed 2/2 tests",
+		},
+		{
+			name: "escaped hash",
+			input: "1..3\n" +
+				`ok 1 - handles \# in names` + "\n" +
+				`ok 2 - issue \#12 # SKIP flaky` + "\n" +
+				`not ok 3 - counts \#s # expected 2` + "\n",
+			passed:  false,
+			results: []string{"passed: handles # in names", "skipped: issue #12", "failed: counts #s"},
+			details: map[string]string{"issue #12": "flaky"},
+		},
+		{
+			name:    "missing plan",
+			input:   "ok 1 - one\nok 2 - two\n",
+			passed:  false,
+			results: []string{"passed: one", "passed: two"},
+			note:    "Test output did not include a plan",
+		},
+		{
+			name:    "short plan",
+			input:   "1..3\nok 1 - one\nok 2 - two\n",
+			passed:  false,
+			results: []string{"passed: one", "passed: two"},
+			note:    "Planned 3 tests but ran 2",
+		},
+		{
+			name:    "plan at the end",
+			input:   "ok 1 - one\nok 2 - two\n1..2\n",
+			passed:  true,
+			results: []string{"passed: one", "passed: two"},
+			note:    "Passed 2/2 tests",
+		},
+		{
+			name:   "skip all",
+			input:  "TAP version 13\n1..0 # skip no database available\n",
+			passed: true,
+			note:   "All tests skipped: no database available",
+		},
+		{
+			name:   "skip all without a reason",
+			input:  "1..0\n",
+			passed: true,
+			note:   "All tests skipped: no reason given",
+		},
+		{
+			name: "bail out",
+			input: "1..3\n" +
+				"ok 1 - connects\n" +
+				"Bail out! database went away\n" +
+				"ok 2 - never seen\n",
+			passed:  false,
+			results: []string{"passed: connects"},
+			note:    "Bailed out: database went away",
+		},
+		{
+			name:   "no results",
+			input:  "hello, world\n",
+			passed: false,
+			note:   "No unit test results found",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			n := newTestNanny()
+			parseTAP(n, test.input)
+			test.check(t, n.ReportCard)
+		})
+	}
+}
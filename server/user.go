@@ -12,7 +12,6 @@ import (
 	"strconv"
 	"sync"
 	"time"
-	"unicode/utf8"
 
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/render"
@@ -667,6 +666,7 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 
 	// filter out solution
 	step.Solution = nil
+	step.SolutionMeta = nil
 
 	// get the problem type for this step
 	problemType, err := getProblemType(tx, step.ProblemType)
@@ -706,6 +706,12 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 		return
 	}
 
+	// only authors can rebuild a dependency cache
+	if action := problemType.Actions[commit.Action]; action != nil && action.Warmup && !currentUser.Author && !currentUser.Admin {
+		loggedHTTPErrorf(w, http.StatusForbidden, "only authors can run the %s action, which rebuilds the dependency cache", commit.Action)
+		return
+	}
+
 	// update an existing commit if it exists
 	// note: this used to include AND action IS NULL AND updated_at > now.Add(-OpenCommitTimeout)
 	openCommit := new(Commit)
@@ -769,9 +775,9 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 
 	// assign a daycare host if needed
 	if bundle.Hostname == "" {
-		typeSet := map[string]bool{problemType.Name: true}
+		typeSet := map[string]*ProblemType{problemType.Name: problemType}
 
-		host, err := daycareRegistrations.Assign(typeSet)
+		host, err := daycareRegistrations.Assign(typeSet, currentUser.ID)
 		if err != nil {
 			log.Printf("error assigning a daycare for this commit: %v", err)
 		} else {
@@ -847,7 +853,7 @@ func saveCommitBundleCommon(now time.Time, w http.ResponseWriter, tx *sql.Tx, cu
 		sort.Strings(names)
 		for _, name := range names {
 			contents := signed.Commit.Files[name]
-			if utf8.Valid(contents) {
+			if !signed.Commit.FileMeta[name].Binary {
 				fmt.Fprintf(&report, "<h1>File: <code>%s</code></h1>\n<pre><code>%s</code></pre>\n",
 					html.EscapeString(name), html.EscapeString(string(contents)))
 			} else {
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+var errAlreadyRunning = errors.New("you already have a run in progress; wait for it to finish and try again")
+
+// errCancelled is reported to a run that was stopped because the same
+// student started another one.
+var errCancelled = errors.New("cancelled: you started another run before this one finished")
+
+// userRun tracks one request from a student on this daycare.
+type userRun struct {
+	// cancel is closed when a newer run asks this one to stop
+	cancel     chan struct{}
+	cancelOnce sync.Once
+
+	// done is closed when this run and every earlier one have finished
+	done chan struct{}
+	prev *userRun
+}
+
+// Cancelled reports whether a newer run has asked this one to stop.
+func (s *userRun) Cancelled() bool {
+	select {
+	case <-s.cancel:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s *userRun) stop() {
+	s.cancelOnce.Do(func() {
+		close(s.cancel)
+	})
+}
+
+// userRuns enforces the concurrentRuns rule: each student has at most
+// one container running on this daycare at a time.
+type userRuns struct {
+	sync.Mutex
+	latest map[int64]*userRun
+}
+
+var activeUserRuns = &userRuns{latest: make(map[int64]*userRun)}
+
+// Begin starts a new run for a student. Depending on the rule, an earlier
+// run is either cancelled ("cancel"), waited for ("queue"), or causes
+// this one to be turned away ("reject"). The caller must wait for the returned
+// run's Wait channel before starting a container, and must call End
+// when finished.
+func (u *userRuns) Begin(userID int64, rule string) (*userRun, error) {
+	u.Lock()
+	defer u.Unlock()
+
+	prev := u.latest[userID]
+	if prev != nil && rule == "reject" {
+		return nil, errAlreadyRunning
+	}
+	s := &userRun{
+		cancel: make(chan struct{}),
+		done:   make(chan struct{}),
+		prev:   prev,
+	}
+	u.latest[userID] = s
+	if prev != nil && rule != "queue" {
+		prev.stop()
+	}
+	return s, nil
+}
+
+// Wait returns a channel that is closed when every earlier run for the
+// same student has finished.
+func (s *userRun) Wait() <-chan struct{} {
+	if s.prev == nil {
+		closed := make(chan struct{})
+		close(closed)
+		return closed
+	}
+	return s.prev.done
+}
+
+// End records that a run has finished.
+func (u *userRuns) End(userID int64, s *userRun) {
+	u.Lock()
+	if u.latest[userID] == s {
+		delete(u.latest, userID)
+	}
+	u.Unlock()
+
+	// a run that quits early still counts as running until
+	// the ones before it are gone
+	go func() {
+		<-s.Wait()
+		s.prev = nil
+		close(s.done)
+	}()
+}
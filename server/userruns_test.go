@@ -0,0 +1,109 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// closedWithin reports whether a channel is closed within a short time.
+func closedWithin(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	case <-time.After(time.Second):
+		return false
+	}
+}
+
+// isClosed reports whether a channel is already closed.
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestUserRuns(t *testing.T) {
+	tests := []struct {
+		rule      string
+		err       error // from starting a second run while the first is active
+		cancelled bool  // whether the second run cancels the first
+	}{
+		{rule: "cancel", cancelled: true},
+		{rule: "", cancelled: true},
+		{rule: "queue", cancelled: false},
+		{rule: "reject", err: errAlreadyRunning},
+	}
+	for _, test := range tests {
+		runs := &userRuns{latest: make(map[int64]*userRun)}
+		first, err := runs.Begin(1, test.rule)
+		if err != nil {
+			t.Errorf("%q: first run: unexpected error: %v", test.rule, err)
+			continue
+		}
+		if !isClosed(first.Wait()) {
+			t.Errorf("%q: first run has to wait with nothing ahead of it", test.rule)
+		}
+
+		// another student is not affected
+		other, err := runs.Begin(2, test.rule)
+		if err != nil || !isClosed(other.Wait()) || first.Cancelled() {
+			t.Errorf("%q: a run by another student interfered with the first", test.rule)
+		}
+
+		second, err := runs.Begin(1, test.rule)
+		if err != test.err {
+			t.Errorf("%q: second run: got error %v, want %v", test.rule, err, test.err)
+			continue
+		}
+		if first.Cancelled() != test.cancelled {
+			t.Errorf("%q: first run cancelled is %v, want %v", test.rule, first.Cancelled(), test.cancelled)
+		}
+		if second == nil {
+			// turned away, so the student can start again once the first is done
+			runs.End(1, first)
+			if _, err := runs.Begin(1, test.rule); err != nil {
+				t.Errorf("%q: run after the first ended: unexpected error: %v", test.rule, err)
+			}
+			continue
+		}
+
+		// the second run never overlaps the first, even if the first was cancelled
+		if isClosed(second.Wait()) {
+			t.Errorf("%q: second run may start before the first has ended", test.rule)
+		}
+		runs.End(1, first)
+		if !closedWithin(second.Wait()) {
+			t.Errorf("%q: second run still waiting after the first ended", test.rule)
+		}
+		if second.Cancelled() {
+			t.Errorf("%q: second run was cancelled", test.rule)
+		}
+		runs.End(1, second)
+		runs.End(2, other)
+		if len(runs.latest) != 0 {
+			t.Errorf("%q: %d students still have runs after all ended", test.rule, len(runs.latest))
+		}
+	}
+}
+
+func TestUserRunsChain(t *testing.T) {
+	// a run that quits early still holds back the ones after it
+	runs := &userRuns{latest: make(map[int64]*userRun)}
+	first, _ := runs.Begin(1, "queue")
+	second, _ := runs.Begin(1, "queue")
+	third, _ := runs.Begin(1, "queue")
+
+	runs.End(1, second)
+	time.Sleep(10 * time.Millisecond)
+	if isClosed(third.Wait()) {
+		t.Errorf("third run may start while the first is still active")
+	}
+	runs.End(1, first)
+	if !closedWithin(third.Wait()) {
+		t.Errorf("third run still waiting after the first two ended")
+	}
+	runs.End(1, third)
+}
@@ -3,10 +3,19 @@ package main
 import (
 	"encoding/xml"
 	"fmt"
-	"regexp"
+	"log"
+	"strconv"
+	"strings"
 	"time"
+
+	. "github.com/russross/codegrinder/types"
 )
 
+func init() {
+	registerParser("xunit", runAndParseXUnit)
+	registerParser("check", runAndParseCheckXML)
+}
+
 // XUnit types
 type XUnitProgram struct {
 	XMLName  xml.Name      `xml:"testsuites"`
@@ -32,14 +41,38 @@ type XUnitSuite struct {
 }
 
 type XUnitCase struct {
-	Name      string         `xml:"name,attr"`
-	Status    string         `xml:"status,attr"`
-	Time      float64        `xml:"time,attr"`
-	ClassName string         `xml:"classname,attr"`
-	Failure   *XUnitFailure  `xml:"failure"`
-	Error     *XUnitError    `xml:"error"`
-	Disabled  *XUnitDisabled `xml:"disabled"`
-	Skipped   *XUnitSkipped  `xml:"skipped"`
+	Name       string           `xml:"name,attr"`
+	Status     string           `xml:"status,attr"`
+	Time       float64          `xml:"time,attr"`
+	ClassName  string           `xml:"classname,attr"`
+	Failure    *XUnitFailure    `xml:"failure"`
+	Error      *XUnitError      `xml:"error"`
+	Disabled   *XUnitDisabled   `xml:"disabled"`
+	Skipped    *XUnitSkipped    `xml:"skipped"`
+	Properties []*XUnitProperty `xml:"properties>property"`
+}
+
+// XUnitProperty is a name/value pair attached to a test case. A property
+// named weight or points sets how much the test case counts toward the score.
+type XUnitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+// Weight returns the weight given in a test case's properties, or zero.
+func (testCase *XUnitCase) Weight() float64 {
+	for _, prop := range testCase.Properties {
+		if prop.Name != "weight" && prop.Name != "points" {
+			continue
+		}
+		weight, err := strconv.ParseFloat(strings.TrimSpace(prop.Value), 64)
+		if err != nil || weight <= 0.0 {
+			log.Printf("ignoring bad %s property %q for test case %s", prop.Name, prop.Value, testCase.Name)
+			continue
+		}
+		return weight
+	}
+	return 0.0
 }
 
 type XUnitFailure struct {
@@ -93,9 +126,6 @@ func runAndParseXUnit(n *Nanny, cmd []string) {
 	parseXUnit(n, xmlfiles[filename])
 }
 
-var testFailureContextGTest = regexp.MustCompile(`^(tests/[^:/]*:\d+)`)
-var testFailureContextPython = regexp.MustCompile(`File "[^"]*/([^/]+)", line (\d+)`)
-
 func parseXUnit(n *Nanny, contents []byte) {
 	if len(contents) == 0 {
 		n.ReportCard.LogAndFailf("No unit test results found")
@@ -113,65 +143,59 @@ func parseXUnit(n *Nanny, contents []byte) {
 		}
 	}
 
-	// build summary results
-	results.Tests = 0
-	results.Failures = 0
-	results.Disabled = 0
-	results.Skipped = 0
-	results.Errors = 0
-	results.Time = 0
-
-	for _, elt := range results.Suites {
-		results.Tests += elt.Tests
-		results.Failures += elt.Failures
-		results.Disabled += elt.Disabled
-		results.Skipped += elt.Skipped
-		results.Errors += elt.Errors
-		results.Time += elt.Time
-	}
-
-	// form a report card
-	fails := results.Failures + results.Disabled + results.Skipped + results.Errors
-	n.ReportCard.Note = fmt.Sprintf("Passed %d/%d tests in %v",
-		results.Tests-fails, results.Tests, time.Since(n.Start))
-	n.ReportCard.Passed = n.ReportCard.Passed && results.Tests > 0 && fails == 0
-
 	// prepare a report for each test case
+	passed, failed, errors, skipped := 0, 0, 0, 0
 	for _, suite := range results.Suites {
 		for _, testCase := range suite.Cases {
 			name := testCase.Name
 			if testCase.ClassName != "" {
 				name = fmt.Sprintf("%s -> %s", testCase.ClassName, testCase.Name)
 			}
-			if (testCase.Status == "run" || testCase.Status == "") &&
-				testCase.Failure == nil &&
-				testCase.Error == nil &&
-				testCase.Disabled == nil &&
-				testCase.Skipped == nil {
-				n.ReportCard.AddPassedResult(name, "")
-			} else {
-				body := ""
-				if testCase.Failure != nil {
-					body = testCase.Failure.Body
-				} else if testCase.Error != nil {
-					body = testCase.Error.Body
-				} else if testCase.Disabled != nil {
-					body = testCase.Disabled.Body
-				} else if testCase.Skipped != nil {
-					body = testCase.Skipped.Body
-				}
-
-				// try to parse context
-				ctx := ""
-				if groups := testFailureContextGTest.FindStringSubmatch(body); len(groups) > 1 {
-					ctx = groups[1]
-				} else if groups := testFailureContextPython.FindStringSubmatch(body); len(groups) > 1 {
-					ctx = groups[1] + ":" + groups[2]
-				}
-				n.ReportCard.AddFailedResult(name, body, ctx)
+			var r *ReportCardResult
+			switch {
+			case testCase.Failure != nil:
+				failed++
+				body := testCase.Failure.Body
+				r = n.ReportCard.AddFailedResult(name, body, "")
+			case testCase.Error != nil:
+				errors++
+				body := testCase.Error.Body
+				r = n.ReportCard.AddErrorResult(name, body, "")
+			case testCase.Skipped != nil:
+				skipped++
+				r = n.ReportCard.AddSkippedResult(name, xunitSkipMessage(testCase.Skipped.Message, testCase.Skipped.Body))
+			case testCase.Disabled != nil:
+				skipped++
+				r = n.ReportCard.AddSkippedResult(name, xunitSkipMessage(testCase.Disabled.Message, testCase.Disabled.Body))
+			case testCase.Status == "run" || testCase.Status == "":
+				passed++
+				r = n.ReportCard.AddPassedResult(name, "")
+			default:
+				// gtest marks disabled tests with status="notrun"
+				skipped++
+				r = n.ReportCard.AddSkippedResult(name, testCase.Status)
 			}
+			r.Weight = testCase.Weight()
 		}
 	}
+
+	// form a report card
+	n.ReportCard.Note = fmt.Sprintf("Passed %d/%d tests in %v",
+		passed, passed+failed+errors, time.Since(n.Start))
+	if errors > 0 {
+		n.ReportCard.Note += fmt.Sprintf(", errors %d", errors)
+	}
+	if skipped > 0 {
+		n.ReportCard.Note += fmt.Sprintf(", skipped %d", skipped)
+	}
+	n.ReportCard.Passed = n.ReportCard.Passed && passed > 0 && failed+errors == 0
+}
+
+func xunitSkipMessage(message, body string) string {
+	if strings.TrimSpace(body) != "" {
+		return body
+	}
+	return message
 }
 
 // check XML types
@@ -248,12 +272,9 @@ func parseCheckXML(n *Nanny, contents []byte) {
 			case "failure":
 				failures++
 				n.ReportCard.AddFailedResult(test.ID, test.Message, test.Function)
-			case "error":
-				errors++
-				n.ReportCard.AddFailedResult(test.ID, test.Message, test.Function)
 			default:
 				errors++
-				n.ReportCard.AddFailedResult(test.ID, test.Message, test.Function)
+				n.ReportCard.AddErrorResult(test.ID, test.Message, test.Function)
 			}
 		}
 	}
@@ -0,0 +1,8 @@
+-- Adds the file metadata columns to a database created before they were
+-- part of schema.sql. Existing rows get 'null', which means no files are
+-- marked executable or binary.
+--
+--     sqlite3 "$CODEGRINDERROOT"/db/codegrinder.db < setup/migrate-file-meta.sql
+ALTER TABLE problem_steps ADD COLUMN file_meta text NOT NULL DEFAULT 'null';
+ALTER TABLE problem_steps ADD COLUMN solution_meta text NOT NULL DEFAULT 'null';
+ALTER TABLE commits ADD COLUMN file_meta text NOT NULL DEFAULT 'null';
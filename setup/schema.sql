@@ -1,6 +1,11 @@
 CREATE TABLE problem_types (
     name                    text NOT NULL,
     image                   text NOT NULL,
+    image_digest            text,
+    runtime                 text CHECK(runtime IS NULL OR runtime IN ('runc', 'runsc', 'kata')),
+    seccomp_profile         text,
+    home_size               integer NOT NULL DEFAULT 0,
+    cache_path              text,
 
     PRIMARY KEY (name)
 );
@@ -9,9 +14,11 @@ CREATE TABLE problem_type_actions (
     problem_type            text NOT NULL,
     action                  text NOT NULL,
     command                 text NOT NULL,
-    parser                  text CHECK(parser IS NULL OR parser IN ('xunit', 'check')),
+    parser                  text,
     message                 text NOT NULL,
     interactive             boolean NOT NULL,
+    pty                     boolean NOT NULL DEFAULT 0,
+    warmup                  boolean NOT NULL DEFAULT 0,
 
     max_cpu                 integer NOT NULL,
     max_session             integer NOT NULL,
@@ -25,6 +32,20 @@ CREATE TABLE problem_type_actions (
     FOREIGN KEY (problem_type) REFERENCES problem_types (name) ON DELETE CASCADE ON UPDATE CASCADE
 );
 
+CREATE TABLE problem_type_action_stages (
+    problem_type            text NOT NULL,
+    action                  text NOT NULL,
+    sequence                integer NOT NULL,
+    name                    text NOT NULL,
+    command                 text NOT NULL,
+    parser                  text,
+    max_timeout             integer,
+    stop_on_failure         boolean NOT NULL,
+
+    PRIMARY KEY (problem_type, action, sequence),
+    FOREIGN KEY (problem_type, action) REFERENCES problem_type_actions (problem_type, action) ON DELETE CASCADE ON UPDATE CASCADE
+);
+
 CREATE TABLE problems (
     id                      integer PRIMARY KEY,
     unique_id               text NOT NULL,
@@ -44,8 +65,10 @@ CREATE TABLE problem_steps (
     instructions            text NOT NULL,
     weight                  real NOT NULL,
     files                   text NOT NULL,
+    file_meta               text NOT NULL DEFAULT 'null',
     whitelist               text NOT NULL,
     solution                text NOT NULL,
+    solution_meta           text NOT NULL DEFAULT 'null',
 
     PRIMARY KEY (problem_id, step),
     FOREIGN KEY (problem_id) REFERENCES problems (id) ON DELETE CASCADE ON UPDATE CASCADE,
@@ -148,6 +171,7 @@ CREATE TABLE commits (
     action                  text,
     note                    text,
     files                   text NOT NULL,
+    file_meta               text NOT NULL DEFAULT 'null',
     transcript              text NOT NULL,
     report_card             text NOT NULL,
     score                   real,
@@ -42,6 +42,14 @@ type DaycareRequest struct {
 	CommitBundle *CommitBundle `json:"commitBundle,omitempty"`
 	Stdin        []byte        `json:"stdin,omitempty"`
 	CloseStdin   bool          `json:"closeStdin,omitempty"`
+	Resize       *TerminalSize `json:"resize,omitempty"`
+}
+
+// TerminalSize is the size of the client's terminal window, sent to the
+// daycare for actions that run on a terminal.
+type TerminalSize struct {
+	Rows int `json:"rows"`
+	Cols int `json:"cols"`
 }
 
 // DaycareResponse represents a single response from the daycare back to a client.
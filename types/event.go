@@ -3,6 +3,9 @@ package types
 import (
 	"fmt"
 	"log"
+	"path"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -15,6 +18,63 @@ type ReportCard struct {
 	Note     string              `json:"note"`
 	Duration time.Duration       `json:"duration"`
 	Results  []*ReportCardResult `json:"results"`
+	Usage    *ResourceUsage      `json:"usage,omitempty"`
+	Stages   []*ReportCardStage  `json:"stages,omitempty"`
+
+	// whether skipped results fail the run and count against the score,
+	// as set by the problem's countSkipped option
+	CountSkipped bool `json:"countSkipped,omitempty"`
+}
+
+// ReportCardStage summarizes one stage of a multi-stage action.
+// Its results are the ones in the report card tagged with its name.
+type ReportCardStage struct {
+	Name     string        `json:"name"`
+	Passed   bool          `json:"passed"`
+	Skipped  bool          `json:"skipped,omitempty"` // not run because an earlier stage failed
+	Note     string        `json:"note,omitempty"`
+	Duration time.Duration `json:"duration"`
+}
+
+func (s *ReportCardStage) String() string {
+	switch {
+	case s.Skipped:
+		return fmt.Sprintf("%s: skipped", s.Name)
+	case s.Passed:
+		return fmt.Sprintf("%s: passed in %v", s.Name, s.Duration.Round(time.Millisecond))
+	case s.Note != "":
+		return fmt.Sprintf("%s: failed in %v: %s", s.Name, s.Duration.Round(time.Millisecond), s.Note)
+	default:
+		return fmt.Sprintf("%s: failed in %v", s.Name, s.Duration.Round(time.Millisecond))
+	}
+}
+
+// ResourceUsage gives the resources used by a run, as measured by the
+// container's cgroup. Fields that could not be measured are zero.
+type ResourceUsage struct {
+	CPUTime    time.Duration `json:"cpuTime"`
+	PeakMemory int64         `json:"peakMemory"` // bytes
+	PeakPids   int64         `json:"peakPids"`
+	OOMKilled  bool          `json:"oomKilled,omitempty"`
+	WallTime   time.Duration `json:"wallTime"`
+}
+
+func (u *ResourceUsage) String() string {
+	parts := []string{}
+	if u.CPUTime > 0 {
+		parts = append(parts, fmt.Sprintf("cpu %v", u.CPUTime.Round(time.Millisecond)))
+	}
+	if u.PeakMemory > 0 {
+		parts = append(parts, fmt.Sprintf("memory %.1f MB peak", float64(u.PeakMemory)/(1024*1024)))
+	}
+	if u.PeakPids > 0 {
+		parts = append(parts, fmt.Sprintf("%d processes peak", u.PeakPids))
+	}
+	parts = append(parts, fmt.Sprintf("wall %v", u.WallTime.Round(time.Millisecond)))
+	if u.OOMKilled {
+		parts = append(parts, "out of memory")
+	}
+	return strings.Join(parts, ", ")
 }
 
 // ReportCardResult Outcomes:
@@ -31,11 +91,53 @@ type ReportCard struct {
 // Context:
 //
 //	path/to/file.py:line#
+//
+// Location: the same place broken into parts, when it can be found
 type ReportCardResult struct {
-	Name    string `json:"name"`
-	Outcome string `json:"outcome"`
-	Details string `json:"details,omitempty"`
-	Context string `json:"context,omitempty"`
+	Name     string              `json:"name"`
+	Outcome  string              `json:"outcome"`
+	Details  string              `json:"details,omitempty"`
+	Context  string              `json:"context,omitempty"`
+	Location *ReportCardLocation `json:"location,omitempty"`
+	Stage    string              `json:"stage,omitempty"`
+	Weight   float64             `json:"weight,omitempty"` // zero means the default of 1.0
+}
+
+// ReportCardLocation is where a test failed, relative to the student's
+// directory. Line and column numbers are one-based, and zero if unknown.
+type ReportCardLocation struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Function string `json:"function,omitempty"`
+}
+
+// Position gives the file, line, and column in the usual file:line:column
+// form, leaving off the parts that are not known.
+func (loc *ReportCardLocation) Position() string {
+	s := loc.File
+	if loc.Line > 0 {
+		s += ":" + strconv.Itoa(loc.Line)
+		if loc.Column > 0 {
+			s += ":" + strconv.Itoa(loc.Column)
+		}
+	}
+	return s
+}
+
+func (loc *ReportCardLocation) String() string {
+	if loc.Function != "" {
+		return fmt.Sprintf("%s in %s", loc.Position(), loc.Function)
+	}
+	return loc.Position()
+}
+
+// Points returns how much a result counts toward the score.
+func (elt *ReportCardResult) Points() float64 {
+	if elt.Weight <= 0.0 {
+		return 1.0
+	}
+	return elt.Weight
 }
 
 // EventMessage follows one of these forms:
@@ -49,6 +151,9 @@ type ReportCardResult struct {
 //	error Error
 //	reportcard ReportCard
 //	files Files
+//	queued QueuePosition QueueWait
+//	usage Usage
+//	stage Stage
 type EventMessage struct {
 	Time        time.Time         `json:"time"`
 	Event       string            `json:"event"`
@@ -58,6 +163,11 @@ type EventMessage struct {
 	Error       string            `json:"error,omitempty"`
 	ReportCard  *ReportCard       `json:"reportCard,omitempty"`
 	Files       map[string][]byte `json:"files,omitempty"`
+
+	QueuePosition int            `json:"queuePosition,omitempty"`
+	QueueWait     time.Duration  `json:"queueWait,omitempty"`
+	Usage         *ResourceUsage `json:"usage,omitempty"`
+	Stage         string         `json:"stage,omitempty"`
 }
 
 func (e *EventMessage) String() string {
@@ -83,6 +193,12 @@ func (e *EventMessage) String() string {
 			names = append(names, name)
 		}
 		return fmt.Sprintf("event: files %s", strings.Join(names, ", "))
+	case "queued":
+		return fmt.Sprintf("event: queued position=%d wait=%v", e.QueuePosition, e.QueueWait)
+	case "usage":
+		return fmt.Sprintf("event: usage %s", e.Usage)
+	case "stage":
+		return fmt.Sprintf("event: stage %s", e.Stage)
 	default:
 		return fmt.Sprintf("unknown event: %s", e.Event)
 	}
@@ -104,6 +220,15 @@ func (e *EventMessage) Dump() string {
 		return string(e.StreamData)
 	case "error":
 		return fmt.Sprintf("Error: %s\r\n", e.Error)
+	case "queued":
+		if e.QueueWait <= 0 {
+			return fmt.Sprintf("Waiting for the grader: number %d in line\r\n", e.QueuePosition)
+		}
+		return fmt.Sprintf("Waiting for the grader: number %d in line, about %v\r\n", e.QueuePosition, e.QueueWait.Round(time.Second))
+	case "usage":
+		return fmt.Sprintf("resources used: %s\r\n", e.Usage)
+	case "stage":
+		return fmt.Sprintf("==> stage %s\r\n", e.Stage)
 	default:
 		return ""
 	}
@@ -151,6 +276,21 @@ func (elt *ReportCard) AddFailedResult(name, details, context string) *ReportCar
 	return r
 }
 
+// AddErrorResult records a test that could not run to completion, such as
+// one that crashed or whose fixture raised an exception, as opposed to one
+// that ran and failed an assertion.
+func (elt *ReportCard) AddErrorResult(name, details, context string) *ReportCardResult {
+	elt.Passed = false
+	r := &ReportCardResult{
+		Name:    name,
+		Outcome: "error",
+		Details: details,
+		Context: context,
+	}
+	elt.Results = append(elt.Results, r)
+	return r
+}
+
 func (elt *ReportCard) AddPassedResult(name, details string) *ReportCardResult {
 	r := &ReportCardResult{
 		Name:    name,
@@ -161,19 +301,110 @@ func (elt *ReportCard) AddPassedResult(name, details string) *ReportCardResult {
 	return r
 }
 
-func (elt *ReportCard) ComputeScore() float64 {
-	if len(elt.Results) == 0 {
-		return 0.0
+// AddSkippedResult records a test that did not run or whose failure was
+// expected. It does not fail the report card.
+func (elt *ReportCard) AddSkippedResult(name, details string) *ReportCardResult {
+	r := &ReportCardResult{
+		Name:    name,
+		Outcome: "skipped",
+		Details: details,
+	}
+	elt.Results = append(elt.Results, r)
+	return r
+}
+
+// AddStage merges the report card from one stage of a multi-stage action
+// into this one, tagging its results with the stage name.
+func (elt *ReportCard) AddStage(name string, stage *ReportCard) {
+	for _, result := range stage.Results {
+		result.Stage = name
+		elt.Results = append(elt.Results, result)
+	}
+	elt.Stages = append(elt.Stages, &ReportCardStage{
+		Name:     name,
+		Passed:   stage.Passed,
+		Note:     stage.Note,
+		Duration: stage.Duration,
+	})
+	elt.AddTime(stage.Duration)
+	if !stage.Passed {
+		if stage.Note != "" {
+			elt.Failf("%s: %s", name, stage.Note)
+		} else {
+			elt.Failf("%s failed", name)
+		}
+	}
+}
+
+// SkipStage records a stage that did not run because an earlier one failed.
+func (elt *ReportCard) SkipStage(name string) {
+	elt.Stages = append(elt.Stages, &ReportCardStage{
+		Name:    name,
+		Skipped: true,
+	})
+}
+
+// ApplyWeights sets the weight of each result named in a weights map,
+// as read from a step's weights file. Exact names take precedence over
+// patterns.
+func (elt *ReportCard) ApplyWeights(weights map[string]float64) {
+	if len(weights) == 0 {
+		return
+	}
+	var patterns []string
+	for pattern := range weights {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, result := range elt.Results {
+		if weight, found := weights[result.Name]; found {
+			result.Weight = weight
+			continue
+		}
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, result.Name); matched {
+				result.Weight = weights[pattern]
+				break
+			}
+		}
+	}
+}
+
+// CheckSkipped fails the report card if skipped results count and
+// any were skipped.
+func (elt *ReportCard) CheckSkipped() {
+	if !elt.CountSkipped {
+		return
 	}
-	passed := 0
 	for _, result := range elt.Results {
+		if result.Outcome == "skipped" {
+			elt.Failf("skipped tests count as failures")
+			return
+		}
+	}
+}
+
+// ComputeScore returns the fraction of the available points earned by
+// passing results. Skipped results are left out unless CountSkipped is set.
+// A report card that failed without any failing results is treated as if
+// it had one more failed result worth a single point.
+func (elt *ReportCard) ComputeScore() float64 {
+	passed, total := 0.0, 0.0
+	for _, result := range elt.Results {
+		if result.Outcome == "skipped" && !elt.CountSkipped {
+			continue
+		}
+		total += result.Points()
 		if result.Outcome == "passed" {
-			passed++
+			passed += result.Points()
 		}
 	}
-	score := float64(passed) / float64(len(elt.Results))
+	if total == 0.0 {
+		return 0.0
+	}
+	score := passed / total
 	if !elt.Passed && score >= 1.0 {
-		score = float64(passed) / float64(len(elt.Results)+1)
+		score = passed / (total + 1.0)
 	}
 	return score
 }
@@ -0,0 +1,230 @@
+package types
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of value a problem option can take.
+const (
+	OptionBool    = "bool"    // true or false
+	OptionInt     = "int"     // a whole number in a declared range
+	OptionList    = "list"    // comma-separated file name patterns
+	OptionNetwork = "network" // a network mode, described below
+)
+
+// Network modes a problem can request with the network=<mode> option.
+// By default a container has no network at all. With network=loopback it
+// gets an internal network of its own with no route off the host, and with
+// network=sidecar:<name> that network is shared with a grader-provided
+// sidecar container, reachable under the host name <name>. The daycare
+// decides which image each sidecar name refers to.
+const (
+	NetworkNone     = "none"
+	NetworkLoopback = "loopback"
+	NetworkSidecar  = "sidecar"
+)
+
+var sidecarNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]*$`)
+
+// ProblemOption declares an option a problem can set. Options are written
+// as name=value and apply to every action, or as action:name=value to apply
+// to one action only, taking precedence over an unscoped setting.
+type ProblemOption struct {
+	Name string
+	Type string
+	Min  int64 // range for int options
+	Max  int64
+	Help string
+}
+
+// ProblemOptions is the schema of all options a problem can set.
+var ProblemOptions = []*ProblemOption{
+	{Name: "maxCPU", Type: OptionInt, Min: 1, Max: 3600, Help: "CPU time limit in seconds"},
+	{Name: "maxSession", Type: OptionInt, Min: 1, Max: 86400, Help: "session time limit in seconds"},
+	{Name: "maxTimeout", Type: OptionInt, Min: 1, Max: 3600, Help: "time limit for each command in seconds"},
+	{Name: "maxFD", Type: OptionInt, Min: 10, Max: 65536, Help: "open file limit"},
+	{Name: "maxFileSize", Type: OptionInt, Min: 1, Max: 10240, Help: "file size limit in megabytes"},
+	{Name: "maxMemory", Type: OptionInt, Min: 16, Max: 65536, Help: "memory limit in megabytes"},
+	{Name: "maxThreads", Type: OptionInt, Min: 1, Max: 10000, Help: "process and thread limit"},
+	{Name: "download", Type: OptionList, Help: "files to send back to the student after the action"},
+	{Name: "network", Type: OptionNetwork, Help: "none, loopback, or sidecar:<name>"},
+	{Name: "countSkipped", Type: OptionBool, Help: "whether skipped tests fail the run and count against the score (default true)"},
+}
+
+// OptionValue is a parsed and validated problem option.
+type OptionValue struct {
+	Action string // empty if the option applies to every action
+	Name   string
+	Bool   bool     // for bool options
+	Int    int64    // for int options
+	List   []string // for list options
+	Mode   string   // for network options
+	Extra  string   // sidecar name for network options
+}
+
+func findProblemOption(name string) *ProblemOption {
+	for _, elt := range ProblemOptions {
+		if elt.Name == name {
+			return elt
+		}
+	}
+	return nil
+}
+
+// ParseProblemOption parses one option and checks it against the schema.
+func ParseProblemOption(option string) (*OptionValue, error) {
+	parts := strings.SplitN(option, "=", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("option %q must have the form name=value or action:name=value", option)
+	}
+	value := &OptionValue{Name: strings.TrimSpace(parts[0])}
+	raw := strings.TrimSpace(parts[1])
+	if scope := strings.SplitN(value.Name, ":", 2); len(scope) == 2 {
+		value.Action, value.Name = strings.TrimSpace(scope[0]), strings.TrimSpace(scope[1])
+		if value.Action == "" {
+			return nil, fmt.Errorf("option %q has an empty action name", option)
+		}
+	}
+
+	decl := findProblemOption(value.Name)
+	if decl == nil {
+		var names []string
+		for _, elt := range ProblemOptions {
+			names = append(names, elt.Name)
+		}
+		return nil, fmt.Errorf("unknown option %q: must be one of %s", value.Name, strings.Join(names, ", "))
+	}
+
+	switch decl.Type {
+	case OptionBool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, fmt.Errorf("option %s must be true or false, found %q", decl.Name, raw)
+		}
+		value.Bool = b
+
+	case OptionInt:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("option %s must be a whole number, found %q", decl.Name, raw)
+		}
+		if n < decl.Min || n > decl.Max {
+			return nil, fmt.Errorf("option %s must be between %d and %d, found %d", decl.Name, decl.Min, decl.Max, n)
+		}
+		value.Int = n
+
+	case OptionList:
+		for _, elt := range strings.Split(raw, ",") {
+			elt = strings.TrimSpace(elt)
+			if elt == "" {
+				return nil, fmt.Errorf("option %s has an empty entry", decl.Name)
+			}
+			if _, err := path.Match(elt, ""); err != nil {
+				return nil, fmt.Errorf("option %s has a bad pattern %q", decl.Name, elt)
+			}
+			value.List = append(value.List, elt)
+		}
+
+	case OptionNetwork:
+		switch {
+		case raw == NetworkNone || raw == NetworkLoopback:
+			value.Mode = raw
+		case strings.HasPrefix(raw, NetworkSidecar+":"):
+			value.Mode, value.Extra = NetworkSidecar, strings.TrimPrefix(raw, NetworkSidecar+":")
+			if !sidecarNamePattern.MatchString(value.Extra) {
+				return nil, fmt.Errorf("invalid sidecar name %q in network option: use lower-case letters, digits, and dashes", value.Extra)
+			}
+		default:
+			return nil, fmt.Errorf("invalid network option %q: must be %s, %s, or %s:<name>", raw, NetworkNone, NetworkLoopback, NetworkSidecar)
+		}
+
+	default:
+		return nil, fmt.Errorf("option %s has unknown type %q", decl.Name, decl.Type)
+	}
+	return value, nil
+}
+
+// ValidateProblemOptions checks every option in a list and makes sure no
+// option is set twice for the same action.
+func ValidateProblemOptions(options []string) error {
+	seen := make(map[string]bool)
+	for _, option := range options {
+		value, err := ParseProblemOption(option)
+		if err != nil {
+			return err
+		}
+		key := value.Action + ":" + value.Name
+		if seen[key] {
+			if value.Action != "" {
+				return fmt.Errorf("option %s is set more than once for the %s action", value.Name, value.Action)
+			}
+			return fmt.Errorf("option %s is set more than once", value.Name)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// OptionActions returns the sorted names of the actions that options are
+// scoped to, so callers with the problem types at hand can check them.
+func OptionActions(options []string) []string {
+	seen := make(map[string]bool)
+	var actions []string
+	for _, option := range options {
+		value, err := ParseProblemOption(option)
+		if err != nil || value.Action == "" || seen[value.Action] {
+			continue
+		}
+		seen[value.Action] = true
+		actions = append(actions, value.Action)
+	}
+	sort.Strings(actions)
+	return actions
+}
+
+// OptionsFor returns the options that apply to an action, keyed by name.
+// Invalid options are left out and reported in the returned errors, so
+// problems created before options were checked keep running.
+func OptionsFor(options []string, action string) (map[string]*OptionValue, []error) {
+	values := make(map[string]*OptionValue)
+	var errs []error
+	for _, option := range options {
+		value, err := ParseProblemOption(option)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if value.Action != "" && value.Action != action {
+			continue
+		}
+		if old := values[value.Name]; old != nil && old.Action != "" && value.Action == "" {
+			// an option scoped to this action wins
+			continue
+		}
+		values[value.Name] = value
+	}
+	return values, errs
+}
+
+// NetworkFor returns the network mode and, for sidecar mode, the sidecar
+// name from the options for an action.
+func NetworkFor(values map[string]*OptionValue) (mode, sidecar string) {
+	if value := values["network"]; value != nil {
+		return value.Mode, value.Extra
+	}
+	return NetworkNone, ""
+}
+
+// CountSkippedFor reports whether skipped tests count against a run
+// under the options for an action.
+func CountSkippedFor(values map[string]*OptionValue) bool {
+	if value := values["countSkipped"]; value != nil {
+		return value.Bool
+	}
+	return true
+}
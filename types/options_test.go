@@ -0,0 +1,114 @@
+package types
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseProblemOption(t *testing.T) {
+	tests := []struct {
+		option string
+		want   *OptionValue
+		err    string // a fragment of the expected error
+	}{
+		{option: "maxMemory=1024", want: &OptionValue{Name: "maxMemory", Int: 1024}},
+		{option: "grade:maxCPU=30", want: &OptionValue{Action: "grade", Name: "maxCPU", Int: 30}},
+		{option: " maxThreads = 16 ", want: &OptionValue{Name: "maxThreads", Int: 16}},
+		{option: "maxMemory=16", want: &OptionValue{Name: "maxMemory", Int: 16}},
+		{option: "maxMemory=65536", want: &OptionValue{Name: "maxMemory", Int: 65536}},
+		{option: "countSkipped=false", want: &OptionValue{Name: "countSkipped"}},
+		{option: "download=*.png, out/*.txt", want: &OptionValue{Name: "download", List: []string{"*.png", "out/*.txt"}}},
+		{option: "network=loopback", want: &OptionValue{Name: "network", Mode: NetworkLoopback}},
+		{option: "network=sidecar:db", want: &OptionValue{Name: "network", Mode: NetworkSidecar, Extra: "db"}},
+
+		{option: "maxMemroy=1024", err: `unknown option "maxMemroy"`},
+		{option: "grade:maxMemroy=1024", err: `unknown option "maxMemroy"`},
+		{option: "maxMemory", err: "must have the form"},
+		{option: ":maxMemory=1024", err: "empty action name"},
+		{option: "maxMemory=15", err: "between 16 and 65536"},
+		{option: "maxMemory=65537", err: "between 16 and 65536"},
+		{option: "maxCPU=0", err: "between 1 and 3600"},
+		{option: "maxCPU=-5", err: "between 1 and 3600"},
+		{option: "maxFD=9", err: "between 10 and 65536"},
+		{option: "maxMemory=1G", err: "whole number"},
+		{option: "maxMemory=99999999999999999999", err: "whole number"},
+		{option: "countSkipped=maybe", err: "true or false"},
+		{option: "download=*.png,,*.txt", err: "empty entry"},
+		{option: "download=[", err: "bad pattern"},
+		{option: "network=internet", err: "invalid network option"},
+		{option: "network=sidecar:Bad_Name", err: "invalid sidecar name"},
+	}
+	for _, test := range tests {
+		got, err := ParseProblemOption(test.option)
+		if test.err != "" {
+			if err == nil {
+				t.Errorf("ParseProblemOption(%q): expected an error containing %q", test.option, test.err)
+			} else if !strings.Contains(err.Error(), test.err) {
+				t.Errorf("ParseProblemOption(%q): error %q does not contain %q", test.option, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseProblemOption(%q): unexpected error: %v", test.option, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("ParseProblemOption(%q) = %+v, want %+v", test.option, got, test.want)
+		}
+	}
+}
+
+func TestValidateProblemOptions(t *testing.T) {
+	tests := []struct {
+		options []string
+		err     string
+	}{
+		{options: nil},
+		{options: []string{"maxMemory=1024", "grade:maxMemory=2048", "test:maxMemory=512"}},
+		{options: []string{"maxMemory=1024", "maxMemory=2048"}, err: "set more than once"},
+		{options: []string{"grade:maxMemory=1024", "grade:maxMemory=2048"}, err: "more than once for the grade action"},
+		{options: []string{"maxMemory=1024", "maxMemroy=2048"}, err: "unknown option"},
+	}
+	for _, test := range tests {
+		err := ValidateProblemOptions(test.options)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("ValidateProblemOptions(%q): unexpected error: %v", test.options, err)
+		case test.err != "" && err == nil:
+			t.Errorf("ValidateProblemOptions(%q): expected an error containing %q", test.options, test.err)
+		case test.err != "" && !strings.Contains(err.Error(), test.err):
+			t.Errorf("ValidateProblemOptions(%q): error %q does not contain %q", test.options, err, test.err)
+		}
+	}
+}
+
+func TestOptionsFor(t *testing.T) {
+	options := []string{"grade:maxMemory=2048", "maxMemory=1024", "maxCPU=10", "test:maxCPU=20", "maxMemroy=1", "maxCPU=0"}
+
+	values, errs := OptionsFor(options, "grade")
+	if len(errs) != 2 {
+		t.Errorf("expected 2 errors for the invalid options, found %v", errs)
+	}
+	if got := values["maxMemory"]; got == nil || got.Int != 2048 {
+		t.Errorf("the option scoped to grade should win over the unscoped one, found %+v", got)
+	}
+	if got := values["maxCPU"]; got == nil || got.Int != 10 {
+		t.Errorf("an option scoped to another action should be ignored, found %+v", got)
+	}
+
+	values, _ = OptionsFor(options, "test")
+	if got := values["maxMemory"]; got == nil || got.Int != 1024 {
+		t.Errorf("the unscoped option should apply to test, found %+v", got)
+	}
+	if got := values["maxCPU"]; got == nil || got.Int != 20 {
+		t.Errorf("the option scoped to test should win, found %+v", got)
+	}
+
+	if !CountSkippedFor(values) {
+		t.Errorf("skipped tests should count by default")
+	}
+	if mode, sidecar := NetworkFor(values); mode != NetworkNone || sidecar != "" {
+		t.Errorf("the network should be off by default, found %q %q", mode, sidecar)
+	}
+}
@@ -0,0 +1,87 @@
+package types
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Limits on the names of files in problem steps, commits, and events.
+const (
+	MaxFilePathLength = 255
+	MaxFilePathDepth  = 8
+)
+
+// ValidateFilePath checks that a file name is safe to use as a path relative
+// to a working directory, whether on the daycare or a student's machine.
+// Names use forward slashes, must be relative and already clean, cannot
+// contain "." or ".." elements, and are limited in length and depth. Names
+// that would mean something different on Windows are rejected as well.
+func ValidateFilePath(name string) error {
+	if name == "" {
+		return fmt.Errorf("file name is empty")
+	}
+	if len(name) > MaxFilePathLength {
+		return fmt.Errorf("file name %q is longer than %d bytes", name, MaxFilePathLength)
+	}
+	for _, r := range name {
+		if r < ' ' || r == 0x7f {
+			return fmt.Errorf("file name %q contains a control character", name)
+		}
+	}
+	if strings.ContainsAny(name, `\:`) {
+		return fmt.Errorf("file name %q must use forward slashes and cannot contain a colon", name)
+	}
+	if strings.HasPrefix(name, "/") {
+		return fmt.Errorf("file name %q must be a relative path", name)
+	}
+	parts := strings.Split(name, "/")
+	for _, part := range parts {
+		if part == "." || part == ".." {
+			return fmt.Errorf("file name %q cannot contain %q", name, part)
+		}
+	}
+	if path.Clean(name) != name {
+		return fmt.Errorf("file name %q is not a clean path", name)
+	}
+	if len(parts) > MaxFilePathDepth {
+		return fmt.Errorf("file name %q is more than %d directories deep", name, MaxFilePathDepth)
+	}
+	return nil
+}
+
+// ValidateFilePaths checks every name in a set of files with ValidateFilePath.
+func ValidateFilePaths(files map[string][]byte) error {
+	for name := range files {
+		if err := ValidateFilePath(name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SafeJoin validates a file name and joins it to a directory. It refuses to
+// return a path that passes through a symbolic link below the directory,
+// so a file cannot be written outside of it.
+func SafeJoin(directory, name string) (string, error) {
+	if err := ValidateFilePath(name); err != nil {
+		return "", err
+	}
+	current := directory
+	for _, part := range strings.Split(name, "/") {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("file name %q passes through a symbolic link at %s", name, current)
+		}
+	}
+	return filepath.Join(directory, filepath.FromSlash(name)), nil
+}
@@ -0,0 +1,95 @@
+package types
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateFilePath(t *testing.T) {
+	tests := []struct {
+		name string
+		ok   bool
+	}{
+		{"main.go", true},
+		{"src/lib.rs", true},
+		{"tests/unit/test_one.py", true},
+		{".gitignore", true},
+		{"a/b/c/d/e/f/g/h.txt", true},
+		{strings.Repeat("x", MaxFilePathLength), true},
+
+		{"", false},
+		{"/etc/passwd", false},
+		{"/home/student/main.go", false},
+		{"..", false},
+		{"../main.go", false},
+		{"src/../../main.go", false},
+		{"src/..", false},
+		{".", false},
+		{"./main.go", false},
+		{"src//main.go", false},
+		{"src/", false},
+		{`src\main.go`, false},
+		{"C:main.go", false},
+		{"main\x00.go", false},
+		{"main\n.go", false},
+		{"a/b/c/d/e/f/g/h/i.txt", false},
+		{strings.Repeat("x", MaxFilePathLength+1), false},
+		{strings.Repeat("d/", 100) + "main.go", false},
+	}
+	for _, test := range tests {
+		err := ValidateFilePath(test.name)
+		if test.ok && err != nil {
+			t.Errorf("ValidateFilePath(%q): unexpected error: %v", test.name, err)
+		} else if !test.ok && err == nil {
+			t.Errorf("ValidateFilePath(%q): expected an error", test.name)
+		}
+	}
+}
+
+func TestValidateFilePaths(t *testing.T) {
+	good := map[string][]byte{"main.go": nil, "src/lib.rs": nil}
+	if err := ValidateFilePaths(good); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	bad := map[string][]byte{"main.go": nil, "../escape": nil}
+	if err := ValidateFilePaths(bad); err == nil {
+		t.Errorf("expected an error for a path outside the directory")
+	}
+}
+
+func TestSafeJoin(t *testing.T) {
+	dir := t.TempDir()
+	outside := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "src"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link")); err != nil {
+		t.Skipf("cannot create symbolic links: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		want string // empty if an error is expected
+	}{
+		{"main.go", filepath.Join(dir, "main.go")},
+		{"src/main.go", filepath.Join(dir, "src", "main.go")},
+		{"new/dir/main.go", filepath.Join(dir, "new", "dir", "main.go")},
+		{"link/main.go", ""},
+		{"link", ""},
+		{"../main.go", ""},
+		{"/main.go", ""},
+	}
+	for _, test := range tests {
+		got, err := SafeJoin(dir, test.name)
+		switch {
+		case test.want == "" && err == nil:
+			t.Errorf("SafeJoin(%q): expected an error, got %s", test.name, got)
+		case test.want != "" && err != nil:
+			t.Errorf("SafeJoin(%q): unexpected error: %v", test.name, err)
+		case got != test.want:
+			t.Errorf("SafeJoin(%q) = %s, want %s", test.name, got, test.want)
+		}
+	}
+}
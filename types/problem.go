@@ -5,9 +5,11 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"log"
 	"net/url"
+	"path"
 	"path/filepath"
 	"runtime"
 	"sort"
@@ -23,15 +25,53 @@ import (
 var BeginningOfTime = time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
 
 // ProblemType defines one type of problem.
+//
+// Image is a tag, which can change over time. ImageDigest pins the problem
+// type to the local image ID ("sha256:...", as reported by image inspect)
+// that the daycares report for the tag. When it is set, only daycares whose
+// copy of the image has that ID are used, and containers are started from
+// the image ID instead of the tag. An administrator records it with
+// grind type --pin.
+//
+// The sandbox fields harden the containers for untrusted code. Runtime
+// picks the container runtime (runc, runsc for gVisor, or kata for a
+// lightweight VM), and an empty value uses the daycare's default.
+// SeccompProfile names a file among the problem type's files holding a
+// seccomp profile in the engine's JSON format. A non-zero HomeSize makes
+// the root filesystem read-only, with the home directory and /tmp on
+// writable tmpfs mounts of that many megabytes each.
+//
+// FileMeta is taken from the problem type's files on the TA, so a helper
+// script that is executable there is executable in the container.
+//
+// A non-empty CachePath mounts a dependency cache there, such as prebuilt
+// crates or node modules. Each daycare keeps one cache volume per problem
+// type. Actions marked Warmup get it read-write so an author can populate
+// it, and all other actions get it read-only. The image must provide the
+// directory owned by the student user so the first warmup can write to it.
 type ProblemType struct {
-	Name    string                        `json:"name" meddler:"name"`
-	Image   string                        `json:"image" meddler:"image"`
-	Files   map[string][]byte             `json:"files" meddler:"-"`
-	Actions map[string]*ProblemTypeAction `json:"actions" meddler:"-"`
+	Name        string                        `json:"name" meddler:"name"`
+	Image       string                        `json:"image" meddler:"image"`
+	ImageDigest string                        `json:"imageDigest,omitempty" meddler:"image_digest,zeroisnull"`
+	Files       map[string][]byte             `json:"files" meddler:"-"`
+	FileMeta    map[string]FileMeta           `json:"fileMeta,omitempty" meddler:"-"`
+	Actions     map[string]*ProblemTypeAction `json:"actions" meddler:"-"`
+
+	Runtime        string `json:"runtime,omitempty" meddler:"runtime,zeroisnull"`
+	SeccompProfile string `json:"seccompProfile,omitempty" meddler:"seccomp_profile,zeroisnull"`
+	HomeSize       int64  `json:"homeSize,omitempty" meddler:"home_size"`
+
+	CachePath string `json:"cachePath,omitempty" meddler:"cache_path,zeroisnull"`
 }
 
+// Container runtimes a problem type may ask for.
+var ContainerRuntimes = []string{"runc", "runsc", "kata"}
+
 // ProblemTypeAction defines the labels, parser, interactivity, and handler for a
-// single problem type action.
+// single problem type action. Interactive actions with PTY set run on a
+// terminal inside the container, with stdout and stderr merged into one stream.
+// Warmup actions populate the problem type's dependency cache and can only be
+// run by authors.
 type ProblemTypeAction struct {
 	ProblemType string `json:"problemType" meddler:"problem_type"`
 	Action      string `json:"action" meddler:"action"`
@@ -39,6 +79,8 @@ type ProblemTypeAction struct {
 	Parser      string `json:"parser,omitempty" meddler:"parser,zeroisnull"`
 	Message     string `json:"message" meddler:"message"`
 	Interactive bool   `json:"interactive" meddler:"interactive"`
+	PTY         bool   `json:"pty,omitempty" meddler:"pty"`
+	Warmup      bool   `json:"warmup,omitempty" meddler:"warmup"`
 
 	MaxCPU      int64 `json:"maxCPU" meddler:"max_cpu"`
 	MaxSession  int64 `json:"maxSession" meddler:"max_session"`
@@ -47,6 +89,23 @@ type ProblemTypeAction struct {
 	MaxFileSize int64 `json:"maxFileSize" meddler:"max_file_size"`
 	MaxMemory   int64 `json:"maxMemory" meddler:"max_memory"`
 	MaxThreads  int64 `json:"maxThreads" meddler:"max_threads"`
+
+	Stages []*ProblemTypeActionStage `json:"stages,omitempty" meddler:"-"`
+}
+
+// ProblemTypeActionStage is one step of a multi-stage action. When an action
+// has stages they run in order in the same container in place of its Command
+// and Parser, and each one produces its own section of the report card and
+// transcript. A failed stage with StopOnFailure set ends the pipeline.
+type ProblemTypeActionStage struct {
+	ProblemType   string `json:"problemType" meddler:"problem_type"`
+	Action        string `json:"action" meddler:"action"`
+	Sequence      int    `json:"sequence" meddler:"sequence"`
+	Name          string `json:"name" meddler:"name"`
+	Command       string `json:"command" meddler:"command"`
+	Parser        string `json:"parser,omitempty" meddler:"parser,zeroisnull"`
+	MaxTimeout    int64  `json:"maxTimeout,omitempty" meddler:"max_timeout,zeroisnull"`
+	StopOnFailure bool   `json:"stopOnFailure" meddler:"stop_on_failure"`
 }
 
 type Problem struct {
@@ -64,15 +123,58 @@ type Problem struct {
 // possibly overwriting existing content. The subdirectory contents of Files
 // replace all subdirectory contents in the problem from earlier steps.
 type ProblemStep struct {
-	ProblemID    int64             `json:"problemID" meddler:"problem_id"`
-	Step         int64             `json:"step" meddler:"step"` // note: one-based
-	ProblemType  string            `json:"problemType" meddler:"problem_type"`
-	Note         string            `json:"note" meddler:"note"`
-	Instructions string            `json:"instructions" meddler:"instructions"`
-	Weight       float64           `json:"weight" meddler:"weight"`
-	Files        map[string][]byte `json:"files" meddler:"files,json"`
-	Whitelist    map[string]bool   `json:"whitelist" meddler:"whitelist,json"`
-	Solution     map[string][]byte `json:"solution,omitempty" meddler:"solution,json"`
+	ProblemID    int64               `json:"problemID" meddler:"problem_id"`
+	Step         int64               `json:"step" meddler:"step"` // note: one-based
+	ProblemType  string              `json:"problemType" meddler:"problem_type"`
+	Note         string              `json:"note" meddler:"note"`
+	Instructions string              `json:"instructions" meddler:"instructions"`
+	Weight       float64             `json:"weight" meddler:"weight"`
+	Files        map[string][]byte   `json:"files" meddler:"files,json"`
+	FileMeta     map[string]FileMeta `json:"fileMeta,omitempty" meddler:"file_meta,json"`
+	Whitelist    map[string]bool     `json:"whitelist" meddler:"whitelist,json"`
+	Solution     map[string][]byte   `json:"solution,omitempty" meddler:"solution,json"`
+	SolutionMeta map[string]FileMeta `json:"solutionMeta,omitempty" meddler:"solution_meta,json"`
+}
+
+// FileMeta records what the contents of a file do not: whether it should be
+// executable, and whether it is binary data that must be kept byte for byte.
+// Files with no entry are plain text and not executable.
+type FileMeta struct {
+	Executable bool `json:"executable,omitempty"`
+	Binary     bool `json:"binary,omitempty"`
+}
+
+// Mode returns the permission bits for a file in a container.
+func (meta FileMeta) Mode() int64 {
+	if meta.Executable {
+		return 0777
+	}
+	return 0666
+}
+
+// IsBinary reports whether file contents cannot safely be treated as text.
+func IsBinary(contents []byte) bool {
+	return !utf8.Valid(contents) || bytes.IndexByte(contents, 0) >= 0
+}
+
+// normalizeFileMeta marks files that are not text as binary and drops entries
+// for missing files and entries that record nothing. It returns nil if no
+// entries are left.
+func normalizeFileMeta(files map[string][]byte, meta map[string]FileMeta) map[string]FileMeta {
+	clean := make(map[string]FileMeta)
+	for name, contents := range files {
+		elt := meta[name]
+		if !elt.Binary && IsBinary(contents) {
+			elt.Binary = true
+		}
+		if elt != (FileMeta{}) {
+			clean[name] = elt
+		}
+	}
+	if len(clean) == 0 {
+		return nil
+	}
+	return clean
 }
 
 type ProblemSet struct {
@@ -123,7 +225,9 @@ func (problem *Problem) Normalize(now time.Time, steps []*ProblemStep) error {
 	for i, option := range problem.Options {
 		problem.Options[i] = strings.TrimSpace(option)
 	}
-	sort.Strings(problem.Tags)
+	if err := ValidateProblemOptions(problem.Options); err != nil {
+		return err
+	}
 
 	// check steps and make sure whitelists never drop names
 	if len(steps) == 0 {
@@ -174,14 +278,29 @@ func (problemType *ProblemType) ComputeSignature(secret string) string {
 	// gather all relevant fields
 	v.Add("name", problemType.Name)
 	v.Add("image", problemType.Image)
+	v.Add("image-digest", problemType.ImageDigest)
+	v.Add("runtime", problemType.Runtime)
+	v.Add("seccomp-profile", problemType.SeccompProfile)
+	v.Add("home-size", strconv.FormatInt(problemType.HomeSize, 10))
+	v.Add("cache-path", problemType.CachePath)
 	for name, contents := range problemType.Files {
 		v.Add(fmt.Sprintf("file-%s", name), string(contents))
 	}
+	for name, meta := range problemType.FileMeta {
+		if meta.Executable {
+			v.Add(fmt.Sprintf("file-%s-executable", name), "true")
+		}
+		if meta.Binary {
+			v.Add(fmt.Sprintf("file-%s-binary", name), "true")
+		}
+	}
 	for name, action := range problemType.Actions {
 		v.Add(fmt.Sprintf("action-%s-command", name), action.Command)
 		v.Add(fmt.Sprintf("action-%s-parser", name), action.Parser)
 		v.Add(fmt.Sprintf("action-%s-message", name), action.Message)
 		v.Add(fmt.Sprintf("action-%s-interactive", name), strconv.FormatBool(action.Interactive))
+		v.Add(fmt.Sprintf("action-%s-pty", name), strconv.FormatBool(action.PTY))
+		v.Add(fmt.Sprintf("action-%s-warmup", name), strconv.FormatBool(action.Warmup))
 		v.Add(fmt.Sprintf("action-%s-max-cpu", name), strconv.FormatInt(action.MaxCPU, 10))
 		v.Add(fmt.Sprintf("action-%s-max-session", name), strconv.FormatInt(action.MaxSession, 10))
 		v.Add(fmt.Sprintf("action-%s-max-timeout", name), strconv.FormatInt(action.MaxTimeout, 10))
@@ -189,6 +308,13 @@ func (problemType *ProblemType) ComputeSignature(secret string) string {
 		v.Add(fmt.Sprintf("action-%s-max-file-size", name), strconv.FormatInt(action.MaxFileSize, 10))
 		v.Add(fmt.Sprintf("action-%s-max-memory", name), strconv.FormatInt(action.MaxMemory, 10))
 		v.Add(fmt.Sprintf("action-%s-max-threads", name), strconv.FormatInt(action.MaxThreads, 10))
+		for n, stage := range action.Stages {
+			v.Add(fmt.Sprintf("action-%s-stage-%d-name", name, n), stage.Name)
+			v.Add(fmt.Sprintf("action-%s-stage-%d-command", name, n), stage.Command)
+			v.Add(fmt.Sprintf("action-%s-stage-%d-parser", name, n), stage.Parser)
+			v.Add(fmt.Sprintf("action-%s-stage-%d-max-timeout", name, n), strconv.FormatInt(stage.MaxTimeout, 10))
+			v.Add(fmt.Sprintf("action-%s-stage-%d-stop-on-failure", name, n), strconv.FormatBool(stage.StopOnFailure))
+		}
 	}
 
 	// compute signature
@@ -221,6 +347,14 @@ func (problem *Problem) ComputeSignature(secret string, steps []*ProblemStep) st
 		for name, contents := range step.Files {
 			v.Add(fmt.Sprintf("step-%d-file-%s", step.Step, name), string(contents))
 		}
+		for name, meta := range step.FileMeta {
+			if meta.Executable {
+				v.Add(fmt.Sprintf("step-%d-file-%s-executable", step.Step, name), "true")
+			}
+			if meta.Binary {
+				v.Add(fmt.Sprintf("step-%d-file-%s-binary", step.Step, name), "true")
+			}
+		}
 		for name := range step.Whitelist {
 			v.Add(fmt.Sprintf("step-%d-whitelist-%s", step.Step, name), "true")
 		}
@@ -242,6 +376,29 @@ var ProblemStepDirectoryWhitelist = map[string]bool{
 	"doc":     true,
 }
 
+// WeightsFile is an optional step file giving the weight of individual test
+// cases as a JSON object mapping result names to positive numbers. Names may
+// be patterns in the form accepted by path.Match. Results that are not named
+// have a weight of 1.0.
+const WeightsFile = "weights.json"
+
+// ParseWeights reads the contents of a weights file.
+func ParseWeights(contents []byte) (map[string]float64, error) {
+	weights := make(map[string]float64)
+	if err := json.Unmarshal(contents, &weights); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", WeightsFile, err)
+	}
+	for name, weight := range weights {
+		if weight <= 0.0 {
+			return nil, fmt.Errorf("weight for %q in %s must be positive", name, WeightsFile)
+		}
+		if _, err := path.Match(name, ""); err != nil {
+			return nil, fmt.Errorf("bad pattern %q in %s: %v", name, WeightsFile, err)
+		}
+	}
+	return weights, nil
+}
+
 // fix line endings
 func (step *ProblemStep) Normalize(n int64) error {
 	step.Step = n
@@ -254,20 +411,40 @@ func (step *ProblemStep) Normalize(n int64) error {
 		return fmt.Errorf("error building instructions for step %d: %v", n, err)
 	}
 	step.Instructions = instructions
+	if err := ValidateFilePaths(step.Files); err != nil {
+		return fmt.Errorf("bad file in step %d: %v", n, err)
+	}
+	if err := ValidateFilePaths(step.Solution); err != nil {
+		return fmt.Errorf("bad solution file in step %d: %v", n, err)
+	}
+	for name := range step.Whitelist {
+		if err := ValidateFilePath(name); err != nil {
+			return fmt.Errorf("bad whitelist entry in step %d: %v", n, err)
+		}
+	}
 	if step.Weight <= 0.0 {
 		// default to 1.0
 		step.Weight = 1.0
 	}
+	if contents, exists := step.Files[WeightsFile]; exists {
+		if _, err := ParseWeights(contents); err != nil {
+			return fmt.Errorf("in step %d: %v", n, err)
+		}
+	}
+	step.FileMeta = normalizeFileMeta(step.Files, step.FileMeta)
+	step.SolutionMeta = normalizeFileMeta(step.Solution, step.SolutionMeta)
 	clean := make(map[string][]byte)
 	for name, contents := range step.Files {
 		dir := filepath.Dir(filepath.FromSlash(name))
 		fixed := contents
-		if (dir == "." || !ProblemStepDirectoryWhitelist[dir]) && utf8.Valid(contents) {
+		if step.FileMeta[name].Binary {
+			// binary files are kept exactly as given
+		} else if dir == "." || !ProblemStepDirectoryWhitelist[dir] {
 			fixed = fixLineEndings(contents)
 			if !bytes.Equal(fixed, contents) {
 				log.Printf("fixed line endings for %s", name)
 			}
-		} else if utf8.Valid(contents) {
+		} else {
 			fixed = fixNewLines(contents)
 			if !bytes.Equal(fixed, contents) {
 				log.Printf("fixed newlines for %s", name)
@@ -80,18 +80,19 @@ type Assignment struct {
 
 // Commit defines an attempt at solving one step of a Problem.
 type Commit struct {
-	ID           int64             `json:"id" meddler:"id,pk"`
-	AssignmentID int64             `json:"assignmentID" meddler:"assignment_id"`
-	ProblemID    int64             `json:"problemID" meddler:"problem_id"`
-	Step         int64             `json:"step" meddler:"step"` // note: one-based
-	Action       string            `json:"action" meddler:"action,zeroisnull"`
-	Note         string            `json:"note" meddler:"note,zeroisnull"`
-	Files        map[string][]byte `json:"files" meddler:"files,json"`
-	Transcript   []*EventMessage   `json:"transcript,omitempty" meddler:"transcript,json"`
-	ReportCard   *ReportCard       `json:"reportCard" meddler:"report_card,json"`
-	Score        float64           `json:"score" meddler:"score,zeroisnull"`
-	CreatedAt    time.Time         `json:"createdAt" meddler:"created_at,localtime"`
-	UpdatedAt    time.Time         `json:"updatedAt" meddler:"updated_at,localtime"`
+	ID           int64               `json:"id" meddler:"id,pk"`
+	AssignmentID int64               `json:"assignmentID" meddler:"assignment_id"`
+	ProblemID    int64               `json:"problemID" meddler:"problem_id"`
+	Step         int64               `json:"step" meddler:"step"` // note: one-based
+	Action       string              `json:"action" meddler:"action,zeroisnull"`
+	Note         string              `json:"note" meddler:"note,zeroisnull"`
+	Files        map[string][]byte   `json:"files" meddler:"files,json"`
+	FileMeta     map[string]FileMeta `json:"fileMeta,omitempty" meddler:"file_meta,json"`
+	Transcript   []*EventMessage     `json:"transcript,omitempty" meddler:"transcript,json"`
+	ReportCard   *ReportCard         `json:"reportCard" meddler:"report_card,json"`
+	Score        float64             `json:"score" meddler:"score,zeroisnull"`
+	CreatedAt    time.Time           `json:"createdAt" meddler:"created_at,localtime"`
+	UpdatedAt    time.Time           `json:"updatedAt" meddler:"updated_at,localtime"`
 }
 
 // isInstructorRole returns true if the given LTI Roles field indicates this
@@ -155,6 +156,14 @@ func (commit *Commit) ComputeSignature(secret, problemTypeSignature, problemSign
 	for name, contents := range commit.Files {
 		v.Add(fmt.Sprintf("file-%s", name), string(contents))
 	}
+	for name, meta := range commit.FileMeta {
+		if meta.Executable {
+			v.Add(fmt.Sprintf("file-%s-executable", name), "true")
+		}
+		if meta.Binary {
+			v.Add(fmt.Sprintf("file-%s-binary", name), "true")
+		}
+	}
 	for n, event := range commit.Transcript {
 		v.Add(fmt.Sprintf("transcript-%d", n), event.String())
 	}
@@ -162,6 +171,12 @@ func (commit *Commit) ComputeSignature(secret, problemTypeSignature, problemSign
 		v.Add("reportcard-passed", strconv.FormatBool(commit.ReportCard.Passed))
 		v.Add("reportcard-note", commit.ReportCard.Note)
 		v.Add("reportcard-duration", commit.ReportCard.Duration.String())
+		if commit.ReportCard.CountSkipped {
+			v.Add("reportcard-countskipped", "true")
+		}
+		if commit.ReportCard.Usage != nil {
+			v.Add("reportcard-usage", commit.ReportCard.Usage.String())
+		}
 		for n, result := range commit.ReportCard.Results {
 			v.Add(fmt.Sprintf("reportcard-%d-name", n), result.Name)
 			v.Add(fmt.Sprintf("reportcard-%d-outcome", n), result.Outcome)
@@ -171,6 +186,18 @@ func (commit *Commit) ComputeSignature(secret, problemTypeSignature, problemSign
 			if result.Context != "" {
 				v.Add(fmt.Sprintf("reportcard-%d-context", n), result.Context)
 			}
+			if result.Location != nil {
+				v.Add(fmt.Sprintf("reportcard-%d-location", n), result.Location.String())
+			}
+			if result.Stage != "" {
+				v.Add(fmt.Sprintf("reportcard-%d-stage", n), result.Stage)
+			}
+			if result.Weight != 0.0 {
+				v.Add(fmt.Sprintf("reportcard-%d-weight", n), strconv.FormatFloat(result.Weight, 'g', -1, 64))
+			}
+		}
+		for n, stage := range commit.ReportCard.Stages {
+			v.Add(fmt.Sprintf("reportcard-stage-%d", n), stage.String())
 		}
 	}
 	v.Add("score", strconv.FormatFloat(commit.Score, 'g', -1, 64))
@@ -194,6 +221,9 @@ func (commit *Commit) Normalize(now time.Time, whitelist map[string]bool) error
 	// ID, AssignmentID, Step, and UserID are all checked elsewhere
 	commit.Action = strings.TrimSpace(commit.Action)
 	commit.Note = strings.TrimSpace(commit.Note)
+	if err := ValidateFilePaths(commit.Files); err != nil {
+		return fmt.Errorf("bad file in commit: %v", err)
+	}
 	commit.FilterIncoming(whitelist)
 	if len(commit.Files) == 0 {
 		return fmt.Errorf("commit must have at least one file")
@@ -212,17 +242,25 @@ func (commit *Commit) Normalize(now time.Time, whitelist map[string]bool) error
 }
 
 // filter out files in subdirectories/not on whitelist, and clean up line endings
+// of text files
 func (commit *Commit) FilterIncoming(whitelist map[string]bool) {
 	clean := make(map[string][]byte)
 	for name, contents := range commit.Files {
-		// normalize line endings
 		// only keep files on the whitelist
 		if whitelist[name] {
-			clean[name] = fixLineEndings(contents)
+			clean[name] = contents
 		} else {
 			log.Printf("filtered out %s, which is not on the problem step whitelist", name)
 		}
 	}
+	commit.FileMeta = normalizeFileMeta(clean, commit.FileMeta)
+
+	// normalize line endings
+	for name, contents := range clean {
+		if !commit.FileMeta[name].Binary {
+			clean[name] = fixLineEndings(contents)
+		}
+	}
 	commit.Files = clean
 }
 